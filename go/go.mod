@@ -11,4 +11,5 @@ require (
 	github.com/spf13/viper v1.4.0
 	golang.org/x/oauth2 v0.0.0-20180821212333-d2e6202438be
 	golang.org/x/sync v0.0.0-20190227155943-e225da77a7e6 // indirect
+	gopkg.in/yaml.v3 v3.0.1
 )
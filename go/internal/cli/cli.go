@@ -0,0 +1,317 @@
+// Package cli implements the prwatch command line interface
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/acaloiaro/prwatch/internal"
+	"github.com/acaloiaro/prwatch/internal/config"
+)
+
+// Output formats supported by --format
+const (
+	formatJSON = "json"
+	formatText = "text"
+)
+
+// Exit codes returned by Run
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+const usage = `Usage: prwatch [command] [flags]
+
+Commands:
+  check            check open pull requests for conflicts and act upon them (default)
+  explain-config   explain where each of a user's effective settings comes from
+  list             list open pull requests and their mergeable state
+  validate-config  check the configuration file for problems
+
+Run 'prwatch <command> -h' for a command's flags.
+`
+
+type options struct {
+	configPath string
+	dryRun     bool
+	format     string
+	pr         int
+	repo       string
+	user       string
+}
+
+type command struct {
+	run   func(o options, stdout io.Writer) error
+	flags func(f *flag.FlagSet, o *options)
+}
+
+var commands = map[string]command{
+	"check": {
+		run: check,
+		flags: func(f *flag.FlagSet, o *options) {
+			f.BoolVar(&o.dryRun, "dry-run", false, "report intended issue transitions and comments without making them")
+			f.StringVar(&o.format, "format", formatText, "output format of the run report: text or json")
+			f.IntVar(&o.pr, "pr", 0, "check only the pull request with this number")
+		},
+	},
+	"explain-config": {
+		run: explainConfig,
+		flags: func(f *flag.FlagSet, o *options) {
+			f.StringVar(&o.format, "format", formatText, "output format: text or json")
+			f.StringVar(&o.user, "user", "", "the Github login of the user to explain settings for")
+		},
+	},
+	"list": {
+		run: list,
+		flags: func(f *flag.FlagSet, o *options) {
+			f.StringVar(&o.format, "format", formatText, "output format: text or json")
+		},
+	},
+	"validate-config": {
+		run: validateConfig,
+		flags: func(f *flag.FlagSet, o *options) {
+			f.StringVar(&o.format, "format", formatText, "output format: text or json")
+		},
+	},
+}
+
+// Run runs the prwatch command line interface, returning the process's exit code. When no command is given, "check" is
+// run, which is how the Github Action invokes prwatch.
+func Run(args []string, stdout, stderr io.Writer) int {
+
+	name := "check"
+	if len(args) > 0 && len(args[0]) > 0 && args[0][0] != '-' {
+		name = args[0]
+		args = args[1:]
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command: '%s'\n\n%s", name, usage)
+		return exitUsage
+	}
+
+	var o options
+	f := flag.NewFlagSet(name, flag.ContinueOnError)
+	f.SetOutput(stderr)
+	f.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: prwatch %s [flags]\n\nFlags:\n", name)
+		f.PrintDefaults()
+	}
+
+	f.StringVar(&o.configPath, "config", "", fmt.Sprintf("path, https URL or 'owner/name:path' of the configuration file (default: the first of %s)", strings.Join(config.ConfigPaths, ", ")))
+	f.StringVar(&o.repo, "repo", "", "the repository to watch, e.g. 'owner/name' (default: $GITHUB_REPOSITORY)")
+	cmd.flags(f, &o)
+
+	if err := f.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK
+		}
+
+		return exitUsage
+	}
+
+	if o.format != "" && o.format != formatText && o.format != formatJSON {
+		fmt.Fprintf(stderr, "unknown format: '%s'\n", o.format)
+		return exitUsage
+	}
+
+	if err := cmd.run(o, stdout); err != nil {
+		log.Printf("Finished unsuccessfully: %s", err)
+		return exitError
+	}
+
+	return exitOK
+}
+
+// initialize loads configuration and applies command line overrides to it. Configuration that is not found locally is
+// read from the repository's default branch.
+func initialize(o options) (err error) {
+
+	if o.repo != "" {
+		config.SetEnv("GITHUB_REPOSITORY", o.repo)
+	}
+
+	config.SetRepositoryFileReader(internal.RepositoryFileReader(internal.NewGithubClient()))
+	config.SetTemplateFuncs(internal.TemplateFuncs)
+	err = config.Load(o.configPath)
+
+	if o.dryRun {
+		config.GlobalEnable(config.DryRun)
+	}
+
+	return
+}
+
+func check(o options, stdout io.Writer) (err error) {
+
+	log.Println("Running...")
+
+	if err = initialize(o); err != nil {
+		return fmt.Errorf("unable to read configuration: %v", err)
+	}
+
+	// report every configuration problem up front, rather than failing part way through a run
+	if err = config.Validate(); err != nil {
+		return
+	}
+
+	if err = internal.CheckEnvironment(); err != nil {
+		return
+	}
+
+	plan := &internal.DefaultExecutionPlan{
+		GithubClient: internal.NewGithubClient(),
+		PullNumber:   o.pr,
+	}
+
+	if err = internal.NewExecutor(plan).Execute(); err != nil {
+		return
+	}
+
+	if o.format == formatJSON {
+		if err = writeJSON(stdout, plan.Report()); err != nil {
+			return
+		}
+	}
+
+	log.Println("Finished...")
+
+	return
+}
+
+type listedPull struct {
+	Number    int    `json:"number"`
+	Title     string `json:"title"`
+	Author    string `json:"author"`
+	Base      string `json:"base"`
+	Head      string `json:"head"`
+	Mergeable string `json:"mergeable"`
+	URL       string `json:"url"`
+}
+
+func list(o options, stdout io.Writer) (err error) {
+
+	// listing pull requests requires no configuration file
+	if err = initialize(o); err != nil {
+		log.Printf("unable to read configuration, continuing without it: %v", err)
+	}
+
+	pulls, err := internal.ListPulls(internal.NewGithubClient())
+	if err != nil {
+		return
+	}
+
+	listed := []listedPull{}
+	for _, pr := range pulls {
+		listed = append(listed, listedPull{
+			Number:    int(pr.Number),
+			Title:     string(pr.Title),
+			Author:    string(pr.Author.Login),
+			Base:      string(pr.BaseRefName),
+			Head:      string(pr.HeadRefName),
+			Mergeable: string(pr.Mergeable),
+			URL:       string(pr.URL),
+		})
+	}
+
+	if o.format == formatJSON {
+		return writeJSON(stdout, listed)
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NUMBER\tMERGEABLE\tAUTHOR\tBASE\tTITLE")
+	for _, p := range listed {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", p.Number, p.Mergeable, p.Author, p.Base, p.Title)
+	}
+
+	return w.Flush()
+}
+
+type validation struct {
+	Valid    bool     `json:"valid"`
+	Problems []string `json:"problems"`
+}
+
+func validateConfig(o options, stdout io.Writer) (err error) {
+
+	v := validation{Problems: []string{}}
+
+	if err = initialize(o); err != nil {
+		v.Problems = append(v.Problems, fmt.Sprintf("unable to read configuration: %v", err))
+	} else if err = config.Validate(); err != nil {
+		if verr, ok := err.(*config.ValidationError); ok {
+			v.Problems = verr.Problems
+		} else {
+			v.Problems = append(v.Problems, err.Error())
+		}
+	}
+
+	v.Valid = len(v.Problems) == 0
+
+	if o.format == formatJSON {
+		if werr := writeJSON(stdout, v); werr != nil {
+			return werr
+		}
+	} else if v.Valid {
+		fmt.Fprintln(stdout, "configuration is valid")
+	} else {
+		fmt.Fprintln(stdout, "configuration is invalid:")
+		for _, p := range v.Problems {
+			fmt.Fprintf(stdout, "  %s\n", p)
+		}
+	}
+
+	if !v.Valid {
+		return fmt.Errorf("invalid configuration")
+	}
+
+	return nil
+}
+
+func explainConfig(o options, stdout io.Writer) (err error) {
+
+	if o.user == "" {
+		return fmt.Errorf("a user is required: --user")
+	}
+
+	if err = initialize(o); err != nil {
+		return fmt.Errorf("unable to read configuration: %v", err)
+	}
+
+	internal.FetchTeamMembers(internal.NewGithubClient())
+
+	resolutions := config.ResolveAll(o.user)
+
+	if o.format == formatJSON {
+		return writeJSON(stdout, resolutions)
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SETTING\tVALUE\tSOURCE")
+	for _, r := range resolutions {
+		value := r.Value
+		if value == nil {
+			value = ""
+		}
+
+		fmt.Fprintf(w, "%s\t%v\t%s\n", r.Setting, value, r.Source())
+	}
+
+	return w.Flush()
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(v)
+}
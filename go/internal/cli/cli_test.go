@@ -0,0 +1,161 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/acaloiaro/prwatch/internal/config"
+)
+
+func writeTestConfig(t *testing.T, yaml string) (path string, cleanup func()) {
+
+	dir, err := ioutil.TempDir("", "prwatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path = filepath.Join(dir, "config.yaml")
+	if err = ioutil.WriteFile(path, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestRunUsage(t *testing.T) {
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+
+	if code := Run([]string{"unknown"}, stdout, stderr); code != exitUsage {
+		t.Errorf("unknown commands should exit with usage code, got: %d", code)
+	}
+
+	if !strings.Contains(stderr.String(), "Commands:") {
+		t.Errorf("unknown commands should print usage: %s", stderr.String())
+	}
+
+	if code := Run([]string{"list", "--format", "xml"}, stdout, stderr); code != exitUsage {
+		t.Errorf("unknown formats should exit with usage code, got: %d", code)
+	}
+	stderr.Reset()
+	if code := Run([]string{"check", "-h"}, stdout, stderr); code != exitOK || !strings.Contains(stderr.String(), strings.Join(config.ConfigPaths, ", ")) {
+		t.Errorf("help should list the default configuration paths: %s", stderr.String())
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+
+	defer config.Reset()
+
+	path, cleanup := writeTestConfig(t, `---
+settings:
+  jira:
+    user: jira-bot
+    host: foo.atlassian.net
+    project_name: FOO
+`)
+	defer cleanup()
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	if code := Run([]string{"validate-config", "--config", path}, stdout, stderr); code != exitOK {
+		t.Errorf("valid configuration should exit ok, got: %d: %s", code, stdout.String())
+	}
+
+	config.Reset()
+
+	path, cleanup = writeTestConfig(t, `---
+settings:
+  dual_pass:
+    wait_duration: soon
+`)
+	defer cleanup()
+
+	stdout.Reset()
+	if code := Run([]string{"validate-config", "--config", path, "--format", "json"}, stdout, stderr); code != exitError {
+		t.Errorf("invalid configuration should exit with an error, got: %d", code)
+	}
+
+	var v validation
+	if err := json.Unmarshal(stdout.Bytes(), &v); err != nil {
+		t.Fatal(err)
+	}
+
+	// the bad duration and three missing jira settings
+	if v.Valid || len(v.Problems) != 4 {
+		t.Errorf("expected four configuration problems, got: %v", v.Problems)
+	}
+}
+
+func TestInitialize(t *testing.T) {
+
+	defer config.Reset()
+
+	path, cleanup := writeTestConfig(t, "---\nsettings: {}\n")
+	defer cleanup()
+
+	err := initialize(options{configPath: path, repo: "acaloiaro/isok", dryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.GetEnv("GITHUB_REPOSITORY") != "acaloiaro/isok" {
+		t.Error("--repo should set the repository")
+	}
+
+	if !config.SettingEnabled(config.DryRun) {
+		t.Error("--dry-run should enable dry run mode")
+	}
+}
+
+func TestExplainConfig(t *testing.T) {
+
+	defer config.Reset()
+
+	path, cleanup := writeTestConfig(t, `---
+settings:
+  issues:
+    enable_comment: false
+users:
+  acaloiaro:
+    settings:
+      pulls:
+        enable_comment: true
+`)
+	defer cleanup()
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	if code := Run([]string{"explain-config", "--config", path, "--user", "acaloiaro", "--format", "json"}, stdout, stderr); code != exitOK {
+		t.Fatalf("explain-config should exit ok, got: %d: %s", code, stderr.String())
+	}
+
+	var resolutions []config.Resolution
+	if err := json.Unmarshal(stdout.Bytes(), &resolutions); err != nil {
+		t.Fatal(err)
+	}
+
+	layers := map[string]config.Layer{}
+	for _, r := range resolutions {
+		layers[r.Setting] = r.Layer
+	}
+
+	expected := map[string]config.Layer{
+		config.IssueComments:    config.LayerGlobal,
+		config.IssueTransitions: config.LayerDefault,
+		config.PullComments:     config.LayerUser,
+	}
+
+	for setting, layer := range expected {
+		if layers[setting] != layer {
+			t.Errorf("expected '%s' to be resolved from the %s layer, got: %s", setting, layer, layers[setting])
+		}
+	}
+
+	if code := Run([]string{"explain-config", "--config", path}, stdout, stderr); code != exitError {
+		t.Errorf("explain-config without a user should exit with an error, got: %d", code)
+	}
+}
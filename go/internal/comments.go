@@ -0,0 +1,171 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+
+	"github.com/acaloiaro/prwatch/internal/config"
+)
+
+// comment bodies left on issues and pull requests, rendered from templates configured by settings.comments, which
+// users may override with users.<login>.settings.comments
+
+const (
+	defaultIssueConflictComment = `{{if .Mention}}{{.Mention}}: {{end}}This issue's pull request has a merge conflict. {{.StatusMessage}}{{conflictFiles .Files}}`
+	defaultIssueResolvedComment = `{{if .Mention}}{{.Mention}}: {{end}}This issue's pull request no longer has a merge conflict. {{.StatusMessage}}`
+	defaultPullConflictComment  = `{{.Mention}}: This pull request has a merge conflict with '{{.Pull.Base}}'.{{conflictFiles .Files}}`
+	defaultPullResolvedComment  = `{{.Mention}}: This pull request no longer has a merge conflict with '{{.Pull.Base}}'.`
+)
+
+// commentData is the data that comment templates are executed with
+type commentData struct {
+	// Mention mentions whoever the comment is addressed to, e.g. '@login' on Github or '[~user]' on Jira. It is empty
+	// when there is no one to mention.
+	Mention string
+	// Author is the Github login of the pull request's author
+	Author string
+	Pull   commentPull
+	// Issue is the key of the issue being commented on, if any
+	Issue string
+	// Files are the files that conflict, when they are known
+	Files []ConflictFile
+	// Status is the status, or label, the issue is transitioned to, if any
+	Status string
+	// StatusMessage describes the status change, if any
+	StatusMessage string
+}
+
+// commentPull is the pull request that a comment is about
+type commentPull struct {
+	Number int
+	Title  string
+	URL    string
+	Base   string
+	Head   string
+}
+
+func newCommentData(pr GithubPullRequest, mention string) commentData {
+	return commentData{
+		Mention: mention,
+		Author:  string(pr.Author.Login),
+		Pull: commentPull{
+			Number: int(pr.Number),
+			Title:  string(pr.Title),
+			URL:    string(pr.URL),
+			Base:   string(pr.BaseRefName),
+			Head:   string(pr.HeadRefName),
+		},
+	}
+}
+
+// newIssueCommentData creates the data for a comment on an issue, describing the change to status, if any
+func newIssueCommentData(i issue, mention, status, statusMessage string) commentData {
+
+	d := newCommentData(i.Pull, mention)
+	d.Author = i.Owner
+	d.Issue = i.ID
+	d.Files = i.Files
+	d.Status = status
+	d.StatusMessage = statusMessage
+
+	return d
+}
+
+func issueConflictComment(d commentData) string {
+	return renderComment(config.CommentIssueConflict, defaultIssueConflictComment, d)
+}
+
+func issueResolvedComment(d commentData) string {
+	return renderComment(config.CommentIssueResolved, defaultIssueResolvedComment, d)
+}
+
+func statusChangedMessage(status string) string {
+	return fmt.Sprintf("This issue's status has changed to: '%s'.", status)
+}
+
+func pullConflictComment(pr GithubPullRequest, files []ConflictFile) string {
+
+	d := newCommentData(pr, "@"+string(pr.Author.Login))
+	d.Files = files
+
+	return renderComment(config.CommentPullConflict, defaultPullConflictComment, d)
+}
+
+func pullResolvedComment(pr GithubPullRequest) string {
+	return renderComment(config.CommentPullResolved, defaultPullResolvedComment, newCommentData(pr, "@"+string(pr.Author.Login)))
+}
+
+// renderComment renders the comment template configured by setting for the pull request's author, falling back to
+// defaultTemplate when none is configured, or the configured template cannot be rendered
+func renderComment(setting, defaultTemplate string, d commentData) string {
+
+	text := config.UserString(d.Author, setting)
+	if text != "" {
+		comment, err := executeCommentTemplate(setting, text, d)
+		if err == nil {
+			return comment
+		}
+
+		log.Printf("unable to render comment template: %v. %s", err, config.CheckMessage(setting))
+	}
+
+	comment, err := executeCommentTemplate(setting, defaultTemplate, d)
+	if err != nil {
+		log.Printf("unable to render default comment template: %v", err)
+	}
+
+	return comment
+}
+
+// TemplateFuncs are the functions available to comment and email templates
+var TemplateFuncs = template.FuncMap{
+	"conflictFiles": conflictFilesMessage,
+	"join":          strings.Join,
+}
+
+func executeCommentTemplate(name, text string, d commentData) (string, error) {
+
+	t, err := template.New(name).Funcs(TemplateFuncs).Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	if err = t.Execute(&b, d); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+// conflictFilesMessage lists conflicting files, when they are known
+func conflictFilesMessage(files []ConflictFile) string {
+
+	if len(files) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("\n\nConflicting files:")
+
+	for _, f := range files {
+		fmt.Fprintf(&b, "\n- %s%s", f.Path, conflictHunksSummary(f.Hunks))
+	}
+
+	return b.String()
+}
+
+// conflictHunksSummary summarizes the number of conflicting hunks in a file, if any
+func conflictHunksSummary(hunks int) string {
+
+	switch hunks {
+	case 0:
+		return ""
+	case 1:
+		return " (1 conflict)"
+	}
+
+	return fmt.Sprintf(" (%d conflicts)", hunks)
+}
@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/acaloiaro/prwatch/internal/config"
+)
+
+func TestCommentTemplates(t *testing.T) {
+
+	settings := []string{config.CommentIssueConflict, config.CommentPullConflict, "users.acaloiaro.settings.comments.issue_conflict"}
+	defer func() {
+		for _, setting := range settings {
+			config.GlobalSet(setting, "")
+		}
+	}()
+
+	pr := GithubPullRequest{Number: 1, Title: "Add foo", URL: "https://github.com/acaloiaro/isok/pull/1", BaseRefName: "master", Author: actor{Login: "acaloiaro"}}
+	files := []ConflictFile{{Path: "foo.go", Hunks: 2}}
+	i := issue{ID: "FOO-1", Owner: "acaloiaro", Files: files, Pull: pr}
+
+	// default templates
+	expected := "@acaloiaro: This issue's pull request has a merge conflict. This issue's status has changed to: 'In Progress'." +
+		"\n\nConflicting files:\n- foo.go (2 conflicts)"
+	if c := issueConflictComment(newIssueCommentData(i, "@acaloiaro", "In Progress", statusChangedMessage("In Progress"))); c != expected {
+		t.Errorf("expected default comment:\n%s\ngot:\n%s", expected, c)
+	}
+
+	// global templates
+	config.GlobalSet(config.CommentPullConflict, "{{.Mention}} #{{.Pull.Number}} conflicts with {{.Pull.Base}}: {{range .Files}}{{.Path}}{{end}}")
+	if c := pullConflictComment(pr, files); c != "@acaloiaro #1 conflicts with master: foo.go" {
+		t.Errorf("comment should have been rendered with the global template, got: %s", c)
+	}
+
+	// user templates override global templates
+	config.GlobalSet(config.CommentIssueConflict, "{{.Issue}} conflicts")
+	config.GlobalSet("users.acaloiaro.settings.comments.issue_conflict", "[{{.Pull.Title}}]({{.Pull.URL}}) conflicts, moving {{.Issue}} to {{.Status}}")
+	expected = "[Add foo](https://github.com/acaloiaro/isok/pull/1) conflicts, moving FOO-1 to In Progress"
+	if c := issueConflictComment(newIssueCommentData(i, "@acaloiaro", "In Progress", "")); c != expected {
+		t.Errorf("expected user comment:\n%s\ngot:\n%s", expected, c)
+	}
+
+	i.Owner = "other"
+	if c := issueConflictComment(newIssueCommentData(i, "@other", "", "")); c != "FOO-1 conflicts" {
+		t.Errorf("comment should have been rendered with the global template for other users, got: %s", c)
+	}
+
+	// invalid templates fall back to the default
+	config.GlobalSet(config.CommentPullConflict, "{{.Missing}}")
+	if c := pullConflictComment(pr, nil); c != "@acaloiaro: This pull request has a merge conflict with 'master'." {
+		t.Errorf("comment should have fallen back to the default template, got: %s", c)
+	}
+}
@@ -3,25 +3,120 @@ package config
 import (
 	"fmt"
 	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
 	"time"
 
 	"github.com/spf13/viper"
 )
 
 const (
+	CommentIssueConflict = "settings.comments.issue_conflict"
+	CommentIssueResolved = "settings.comments.issue_resolved"
+	CommentPullConflict  = "settings.comments.pull_conflict"
+	CommentPullResolved  = "settings.comments.pull_resolved"
+	DryRun               = "settings.dry_run"
 	DualPass             = "settings.dual_pass.enabled"
+	DualPassPollInterval = "settings.dual_pass.poll_interval"
 	DualPassWaitDuration = "settings.dual_pass.wait_duration"
+	EmailFrom            = "settings.notifications.email.from"
+	EmailHost            = "settings.notifications.email.host"
+	EmailPort            = "settings.notifications.email.port"
+	EmailSubject         = "settings.notifications.email.subject"
+	EmailTemplate        = "settings.notifications.email.template"
+	EmailUser            = "settings.notifications.email.user"
+	GithubConflictColumn = "settings.github.conflict_column_id"
+	GithubConflictLabel  = "settings.github.conflict_label"
+	GitConcurrency       = "settings.git.concurrency"
+	GitFetchDepth        = "settings.git.fetch_depth"
+	GitMergeMode         = "settings.git.merge_mode"
+	GitMergeUnknown      = "settings.git.merge_unknown"
 	IssueComments        = "settings.issues.enable_comment"
 	IssueTransitions     = "settings.issues.enable_transition"
 	IssueConflictStatus  = "settings.issues.conflict_status"
+	IssuePatterns        = "settings.issues.patterns"
+	IssueProjectKeys     = "settings.issues.project_keys"
+	IssueProvider        = "settings.issues.provider"
+	IssueResolvedStatus  = "settings.issues.resolved_status"
+	IssueSources         = "settings.issues.sources"
 	Jira                 = "settings.jira.enabled"
 	JiraHost             = "settings.jira.host"
 	JiraProjectName      = "settings.jira.project_name"
+	JiraTransitionAllow  = "settings.jira.transitions.allow_from"
+	JiraTransitionDeny   = "settings.jira.transitions.deny_from"
+	JiraTransitionMap    = "settings.jira.transitions.status_map"
 	JiraUser             = "settings.jira.user"
+	Notifications        = "settings.notifications.enabled"
+	PullComments         = "settings.pulls.enable_comment"
+	PullExcludeAuthors   = "settings.pulls.exclude.authors"
+	PullExcludeBases     = "settings.pulls.exclude.bases"
+	PullExcludeBots      = "settings.pulls.exclude.bots"
+	PullExcludeDrafts    = "settings.pulls.exclude.drafts"
+	PullExcludeLabels    = "settings.pulls.exclude.labels"
+	PullIncludeAuthors   = "settings.pulls.include.authors"
+	PullIncludeBases     = "settings.pulls.include.bases"
+	PullIncludeLabels    = "settings.pulls.include.labels"
+	PullMaxAge           = "settings.pulls.max_age"
+	ReportPath           = "settings.report.path"
+	StatePath            = "settings.state.path"
+)
+
+// Local merge modes that may be configured with settings.git.merge_mode
+const (
+	GitMergeModeMergeTree = "merge-tree"
+	GitMergeModeWorktree  = "worktree"
+)
+
+// commentTemplates are the settings of comment templates, which may be configured globally or per user
+var commentTemplates = []string{CommentIssueConflict, CommentIssueResolved, CommentPullConflict, CommentPullResolved}
+
+// templateFuncs are the functions available to templates, which templates are validated with
+var templateFuncs template.FuncMap
+
+// SetTemplateFuncs sets the functions available to comment and email templates, so that templates are validated with
+// the same functions that they are rendered with
+func SetTemplateFuncs(funcs template.FuncMap) {
+	templateFuncs = funcs
+}
+
+// NotificationModeFormat is the format of each notifier's mode setting, e.g. settings.notifications.slack.mode
+const NotificationModeFormat = "settings.notifications.%s.mode"
+
+// Notification modes that may be configured for each notifier
+const (
+	NotificationModeAlert  = "alert"
+	NotificationModeDigest = "digest"
+)
+
+// Notifiers that may be configured under settings.notifications
+var Notifiers = []string{"email", "slack", "teams", "webhook"}
+
+// Parts of a pull request that issue keys may be extracted from, configured by settings.issues.sources
+const (
+	IssueSourceBody   = "body"
+	IssueSourceBranch = "branch"
+	IssueSourceTitle  = "title"
+)
+
+// Issue providers that may be configured with settings.issues.provider
+const (
+	IssueProviderGithub = "github"
+	IssueProviderJira   = "jira"
+	// IssueProviderNone uses no issue tracker, e.g. to only comment on pull requests
+	IssueProviderNone = "none"
 )
 
 func Reset() {
 	viper.Reset()
+	loaded = nil
+	resetTeamMembers()
+
+	env.Lock()
+	env.m = map[string]string{}
+	env.Unlock()
 }
 
 // CheckMessage is a helper function for building error messages related to configuration settings
@@ -43,25 +138,87 @@ func CheckMessage(details ...string) (msg string) {
 	return
 }
 
-func Initialize() {
-	viper.SetConfigType("yaml")
-	viper.SetConfigName("config")
-	viper.AddConfigPath("./github-actions/prwatch-action/")
-	viper.AddConfigPath("../github-actions/prwatch-action/")
-	viper.AddConfigPath("../../github-actions/prwatch-action/")
+// env are the environment variables overridden with SetEnv
+var env = struct {
+	sync.RWMutex
+	m map[string]string
+}{m: map[string]string{}}
+
+func init() {
+	defaults.SetConfigType("yaml")
+	if err := defaults.ReadConfig(defaultSettings()); err != nil {
+		panic(err)
+	}
+}
+
+// Initialize reads configuration from the first of the default configuration paths that is found
+func Initialize() error {
+
+	return Load("")
+}
+
+// Load reads configuration from the file at path, which may also be an https URL or a file in a Github repository, e.g.
+// 'owner/name:path'. When path is empty, the first of ConfigPaths found locally, or on the repository's default branch
+// is read. Configuration is layered over the files it extends. Defaults and environment
+// variables are available even when no configuration can be read. Defaults are kept apart from configuration, so that
+// configured settings can be told apart from defaults.
+func Load(path string) error {
 
+	viper.SetConfigType("yaml")
 	viper.AutomaticEnv()
 
-	err := viper.ReadInConfig()
+	s, b, err := findConfig(path)
+	if err != nil {
+		return err
+	}
+
+	sources, files, err := readConfig(s, b)
 	if err != nil {
-		log.Fatalf("Unable to read configuration: %s", err)
+		return err
+	}
+
+	f, err := mergeConfig(sources, files)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("configuration read from: %s", s)
+	loaded = f
+
+	return nil
+}
+
+// Validate checks the loaded configuration for unknown settings, values of the wrong type, and settings that prwatch
+// cannot run with, reporting all problems at once, by line
+func Validate() error {
+
+	f := loaded
+	if f == nil {
+		f = &configFile{config: defaultConfig(), lines: map[string]int{}}
+	}
+
+	problems := append(f.problems, f.validate()...)
+
+	// problems are reported in the order they appear in the configuration file
+	sort.SliceStable(problems, func(i, j int) bool {
+		li, lj := problemLine(problems[i]), problemLine(problems[j])
+		return li != 0 && (lj == 0 || li < lj)
+	})
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
 	}
 
-	viper.SetDefault(DualPass, true)
-	viper.SetDefault(DualPassWaitDuration, "60s")
-	viper.SetDefault(IssueComments, true)
-	viper.SetDefault(IssueTransitions, true)
-	viper.SetDefault(Jira, true)
+	return nil
+}
+
+// ValidationError reports every problem found in the configuration
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid configuration:\n  %s", strings.Join(e.Problems, "\n  "))
 }
 
 func GlobalDisable(setting string) {
@@ -79,14 +236,37 @@ func GlobalSet(setting, value string) {
 	viper.Set(setting, value)
 }
 
+func GlobalSetList(setting string, values []string) {
+
+	viper.Set(setting, values)
+}
+
+func GlobalSetMap(setting string, values map[string]string) {
+
+	viper.Set(setting, values)
+}
+
+// SetEnv overrides an environment variable for prwatch, e.g. with a command line flag
 func SetEnv(envVar, value string) {
 
-	viper.Set(envVar, value)
+	env.Lock()
+	defer env.Unlock()
+
+	env.m[envVar] = value
 }
 
+// GetEnv returns an environment variable. Secrets such as tokens are only ever read from the environment, never from
+// configuration files.
 func GetEnv(envVar string) string {
 
-	return viper.GetString(envVar)
+	env.RLock()
+	defer env.RUnlock()
+
+	if value, ok := env.m[envVar]; ok {
+		return value
+	}
+
+	return os.Getenv(envVar)
 }
 
 func UserDisableSetting(user, setting string) {
@@ -101,19 +281,36 @@ func UserEnable(user, setting string) {
 
 func GetString(setting string) string {
 
-	return viper.GetString(setting)
+	return store(setting).GetString(setting)
+}
+
+func GetStringSlice(setting string) []string {
+
+	return store(setting).GetStringSlice(setting)
+}
+
+// GetStringMapString returns a map setting. Note that keys are lower case.
+func GetStringMapString(setting string) map[string]string {
+
+	return store(setting).GetStringMapString(setting)
 }
 
 func GetBool(setting string) bool {
 
-	return viper.GetBool(setting)
+	return store(setting).GetBool(setting)
+}
+
+func GetInt(setting string) int {
+
+	return store(setting).GetInt(setting)
 }
 
 func GetDuration(setting string) time.Duration {
 
-	return viper.GetDuration(setting)
+	return store(setting).GetDuration(setting)
 }
 
+// IsSet reports whether a setting is configured globally. Defaults are not considered to be set.
 func IsSet(setting string) bool {
 
 	return viper.IsSet(setting)
@@ -121,21 +318,23 @@ func IsSet(setting string) bool {
 
 func SettingEnabled(setting string) bool {
 
-	return viper.GetBool(setting)
+	return GetBool(setting)
 }
 
+// UserSettingEnabled reports whether a setting is enabled for a user. See Resolve.
 func UserSettingEnabled(user, setting string) bool {
 
-	// check if it's configured globally first, superceding user settings
-	if IsSet(setting) {
-		return SettingEnabled(setting)
-	}
+	return Resolve(user, setting).bool()
+}
 
-	userSetting := userSettingName(user, setting)
+// UserString returns a user's string setting. See Resolve.
+func UserString(user, setting string) string {
 
-	return viper.GetBool(userSetting)
+	return Resolve(user, setting).string()
 }
 
-func userSettingName(user, setting string) string {
-	return fmt.Sprintf("users.%s.%s", user, setting)
+// UserHandle returns a user's handle for a service, e.g. their Slack member ID, from users.<user>.handles.<service>
+func UserHandle(user, service string) string {
+
+	return viper.GetString(fmt.Sprintf("users.%s.handles.%s", user, service))
 }
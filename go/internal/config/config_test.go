@@ -3,9 +3,15 @@ package config
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"text/template"
 
 	"github.com/spf13/viper"
 )
@@ -29,8 +35,8 @@ func TestInitialize(t *testing.T) {
 		t.Errorf("global setting '%s' should be enabled", IssueTransitions)
 	}
 
-	if UserSettingEnabled("acaloiaro", IssueComments) {
-		t.Errorf("setting should be disabled for acaloiaro: %s", IssueComments)
+	if !UserSettingEnabled("acaloiaro", IssueComments) {
+		t.Errorf("user setting should override global setting for acaloiaro: %s", IssueComments)
 	}
 
 	if !UserSettingEnabled("acaloiaro", IssueTransitions) {
@@ -63,18 +69,69 @@ func TestInitialize(t *testing.T) {
 
 	GlobalDisable(IssueComments)
 	UserEnable("foobar", IssueComments)
-	if UserSettingEnabled("foobar", IssueComments) {
-		t.Error("user-specific setting should be overridden by global setting")
+	if !UserSettingEnabled("foobar", IssueComments) {
+		t.Error("user-specific setting should override global setting")
 	}
 
 	Reset()
 
 	GlobalDisable(IssueTransitions)
 	UserEnable("foobar", IssueTransitions)
+	if !UserSettingEnabled("foobar", IssueTransitions) {
+		t.Error("user-specific setting should override global setting")
+	}
+
+	viper.Set("enforce.settings.issues.enable_transition", false)
 	if UserSettingEnabled("foobar", IssueTransitions) {
-		t.Error("user-specific setting should be overridden by global setting")
+		t.Error("enforced setting should override user-specific setting")
+	}
+
+}
+
+func TestResolve(t *testing.T) {
+
+	defer Reset()
+
+	// teams are ordered by name when no configuration file is loaded
+	viper.Set("teams.platform.members", []string{"foobar"})
+	SetTeamMembers("backend", []string{"FooBar"})
+
+	// defaults are not mistaken for configured settings
+	r := Resolve("foobar", IssueComments)
+	if r.Layer != LayerDefault || r.Value != true || !UserSettingEnabled("foobar", IssueComments) {
+		t.Errorf("setting should resolve to its default: %v", r)
+	}
+
+	GlobalDisable(IssueComments)
+	if r = Resolve("foobar", IssueComments); r.Layer != LayerGlobal || UserSettingEnabled("foobar", IssueComments) {
+		t.Errorf("global setting should override default: %v", r)
 	}
 
+	viper.Set("teams.platform.settings.issues.enable_comment", false)
+	viper.Set("teams.backend.settings.issues.enable_comment", true)
+	if r = Resolve("foobar", IssueComments); r.Layer != LayerTeam || r.Team != "backend" || !UserSettingEnabled("foobar", IssueComments) {
+		t.Errorf("the first team's setting should override global setting: %v", r)
+	}
+
+	if UserSettingEnabled("other", IssueComments) {
+		t.Error("team settings should only apply to the team's members")
+	}
+
+	UserDisableSetting("foobar", IssueComments)
+	r = Resolve("foobar", IssueComments)
+	if r.Layer != LayerUser || UserSettingEnabled("foobar", IssueComments) {
+		t.Errorf("user setting should override team setting: %v", r)
+	}
+
+	expected := "settings.issues.enable_comment = false (user setting 'users.foobar.settings.issues.enable_comment')"
+	if r.String() != expected {
+		t.Errorf("expected explanation:\n%s\ngot:\n%s", expected, r.String())
+	}
+
+	viper.Set("enforce.settings.issues.enable_comment", true)
+	if r = Resolve("foobar", IssueComments); r.Layer != LayerEnforced || !UserSettingEnabled("foobar", IssueComments) {
+		t.Errorf("enforced setting should override every other layer: %v", r)
+	}
 }
 
 func writeConfig(path string) {
@@ -114,3 +171,238 @@ users:
 		fmt.Printf("Unable to write config file: %v", err)
 	}
 }
+
+func TestValidate(t *testing.T) {
+
+	defer Reset()
+
+	loaded = parseConfig(defaultConfig(), []byte(`---
+settings:
+  dual_pass:
+    wait_duration: soon
+  git:
+    concurrency: lots
+  issues:
+    provder: github
+  jira:
+    user: jira-bot
+  pulls:
+    max_age: 0s
+    exclude:
+      bases: ["release/[0-9"]
+users:
+  foobar:
+    settings:
+      issues:
+        enable_coment: true
+`))
+
+	err := Validate()
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("expected a validation error, got: %v", err)
+	}
+
+	expected := []string{
+		"line 4: check config.yaml: 'settings.dual_pass.wait_duration'. e.g. '1m30s'",
+		"line 6: check config.yaml: 'settings.git.concurrency'. cannot unmarshal !!str `lots` into int.",
+		"line 8: unknown setting 'settings.issues.provder'",
+		"line 9: check config.yaml: 'settings.jira.host'. Required when Jira is the issue provider.",
+		"line 9: check config.yaml: 'settings.jira.project_name'. Required when Jira is the issue provider, unless 'settings.issues.project_keys' or 'settings.issues.patterns' are configured.",
+		"line 12: check config.yaml: 'settings.pulls.max_age'. Must be a positive duration, e.g. '720h'.",
+		"line 14: check config.yaml: 'settings.pulls.exclude.bases'. Invalid pattern: 'release/[0-9'.",
+		"line 19: unknown setting 'users.foobar.settings.issues.enable_coment'",
+	}
+
+	if len(verr.Problems) != len(expected) {
+		t.Fatalf("expected %d problems, got: %v", len(expected), verr.Problems)
+	}
+
+	for i, p := range expected {
+		if verr.Problems[i] != p {
+			t.Errorf("expected problem:\n%s\ngot:\n%s", p, verr.Problems[i])
+		}
+	}
+
+	// defaults are applied beneath the configuration file
+	if loaded.config.Settings.Git.MergeMode != GitMergeModeWorktree || loaded.config.Settings.Jira.User != "jira-bot" {
+		t.Errorf("configuration should be layered over defaults: %+v", loaded.config.Settings)
+	}
+}
+
+func TestValidateIssueProviders(t *testing.T) {
+
+	defer Reset()
+
+	configs := map[string]string{
+		// only pull requests are commented on
+		"none": `
+settings:
+  issues:
+    provider: none
+  pulls:
+    enable_comment: true
+`,
+		// the project name is not needed to match issue keys
+		"project keys": `
+settings:
+  issues:
+    project_keys: [FOO, BAR]
+  jira:
+    host: jira.example.com
+    user: jira-bot
+`,
+	}
+
+	for name, c := range configs {
+		loaded = parseConfig(defaultConfig(), []byte(c))
+		if err := Validate(); err != nil {
+			t.Errorf("'%s' configuration should be valid, got: %v", name, err)
+		}
+	}
+}
+
+func TestValidateIssueKeys(t *testing.T) {
+
+	defer Reset()
+
+	loaded = parseConfig(defaultConfig(), []byte(`---
+settings:
+  issues:
+    provider: github
+    sources: [Title, titel]
+    patterns: ["([", "JIRA-(\\d+)"]
+`))
+
+	err := Validate()
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("expected a validation error, got: %v", err)
+	}
+
+	expected := []string{
+		"line 5: check config.yaml: 'settings.issues.sources'. Unknown source 'titel'. Must be any of 'title', 'branch' or 'body'.",
+		"line 6: check config.yaml: 'settings.issues.patterns'. Invalid pattern '([': error parsing regexp: missing closing ]: `[`.",
+	}
+
+	if !reflect.DeepEqual(verr.Problems, expected) {
+		t.Errorf("expected problems:\n%v\ngot:\n%v", expected, verr.Problems)
+	}
+}
+
+func TestValidateTemplateFuncs(t *testing.T) {
+
+	defer Reset()
+	defer SetTemplateFuncs(nil)
+
+	loaded = parseConfig(defaultConfig(), []byte(`---
+settings:
+  issues:
+    provider: none
+  notifications:
+    email:
+      template: "{{range .Pulls}}{{conflictFiles .Files}}{{end}}"
+`))
+
+	SetTemplateFuncs(template.FuncMap{"join": strings.Join})
+	if err := Validate(); err == nil || !strings.Contains(err.Error(), `function "conflictFiles" not defined`) {
+		t.Errorf("templates should be validated with the functions they are rendered with, got: %v", err)
+	}
+
+	SetTemplateFuncs(template.FuncMap{"conflictFiles": func(interface{}) string { return "" }})
+	if err := Validate(); err != nil {
+		t.Errorf("templates using available functions should be valid, got: %v", err)
+	}
+}
+
+func TestLoadExtends(t *testing.T) {
+
+	defer Reset()
+	defer SetRepositoryFileReader(nil)
+
+	dir, err := ioutil.TempDir("", "prwatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "settings:\n  git:\n    concurrency: 2\n    fetch_depth: 10\n  jira:\n    enabled: true\n")
+	}))
+	defer server.Close()
+
+	client := sourceClient
+	sourceClient = server.Client()
+	defer func() { sourceClient = client }()
+
+	// the organization's base configuration extends a URL
+	SetRepositoryFileReader(func(repository, path string) ([]byte, error) {
+		if repository != "my-org/.github" || path != "prwatch.yaml" {
+			return nil, nil
+		}
+
+		return []byte("extends: " + server.URL + "\nsettings:\n  git:\n    concurrency: 3\n  issues:\n    provder: jira\n"), nil
+	})
+
+	path := filepath.Join(dir, "config.yaml")
+	ioutil.WriteFile(path, []byte("extends: my-org/.github:prwatch.yaml\nsettings:\n  git:\n    fetch_depth: 1\n"), 0644)
+
+	if err = Load(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if GetInt(GitConcurrency) != 3 || GetInt(GitFetchDepth) != 1 || !GetBool(Jira) {
+		t.Errorf("configuration should be layered over the files it extends: concurrency %d, fetch depth %d",
+			GetInt(GitConcurrency), GetInt(GitFetchDepth))
+	}
+
+	if s := loaded.config.Settings.Git; s.Concurrency != 3 || s.FetchDepth != 1 {
+		t.Errorf("typed configuration should be layered over the files it extends: %+v", s)
+	}
+
+	err = Validate()
+	if err == nil || !strings.Contains(err.Error(), "my-org/.github:prwatch.yaml: line 6: unknown setting 'settings.issues.provder'") {
+		t.Errorf("problems in extended files should name the file: %v", err)
+	}
+
+	// files may not extend themselves
+	ioutil.WriteFile(path, []byte("extends: config.yaml\n"), 0644)
+	if err = Load(path); err == nil || !strings.Contains(err.Error(), "extends itself") {
+		t.Errorf("configuration extending itself should be an error, got: %v", err)
+	}
+
+	// configuration may be loaded from anything that can be extended, but never over plain http
+	Reset()
+	if err = Load(server.URL); err != nil || GetInt(GitConcurrency) != 2 {
+		t.Errorf("configuration should be loaded from https URLs, got: %v", err)
+	}
+
+	Reset()
+	if err = Load("my-org/.github:prwatch.yaml"); err != nil || GetInt(GitConcurrency) != 3 {
+		t.Errorf("configuration should be loaded from Github repositories, got: %v", err)
+	}
+
+	insecure := strings.Replace(server.URL, "https://", "http://", 1)
+	if err = Load(insecure); err == nil || !strings.Contains(err.Error(), "only be read from https URLs") {
+		t.Errorf("configuration should not be read over plain http, got: %v", err)
+	}
+
+	ioutil.WriteFile(path, []byte("extends: "+insecure+"\n"), 0644)
+	if err = Load(path); err == nil || !strings.Contains(err.Error(), "only be read from https URLs") {
+		t.Errorf("configuration should not be extended over plain http, got: %v", err)
+	}
+}
+
+func TestGetEnv(t *testing.T) {
+
+	defer Reset()
+
+	viper.SetConfigType("yaml")
+	viper.ReadConfig(strings.NewReader("jira_api_token: from-config\n"))
+	os.Setenv("JIRA_API_TOKEN", "from-env")
+	defer os.Unsetenv("JIRA_API_TOKEN")
+
+	if token := GetEnv("JIRA_API_TOKEN"); token != "from-env" {
+		t.Errorf("secrets should only be read from the environment, got: %s", token)
+	}
+}
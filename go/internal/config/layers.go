@@ -0,0 +1,165 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Layer is a level of configuration that a setting's value may come from. Settings are resolved for a user from the
+// highest layer that sets them: enforced, then user, then team, then global and finally the default.
+type Layer string
+
+// Layers that settings may be resolved from
+const (
+	// LayerDefault is prwatch's built-in default
+	LayerDefault Layer = "default"
+	// LayerGlobal is settings.*
+	LayerGlobal Layer = "global"
+	// LayerTeam is teams.<team>.settings.* for each of the user's teams, in the order they are configured
+	LayerTeam Layer = "team"
+	// LayerUser is users.<login>.settings.*
+	LayerUser Layer = "user"
+	// LayerEnforced is enforce.settings.*, which overrides every team's and user's settings
+	LayerEnforced Layer = "enforced"
+)
+
+// settingsPrefix prefixes every setting that may be layered
+const settingsPrefix = "settings."
+
+// defaults holds the default value of settings, separately from configured values, so that configured values can be
+// told apart from defaults
+var defaults = viper.New()
+
+// UserSettings are the settings that may be configured per team and user
+var UserSettings = []string{
+	CommentIssueConflict,
+	CommentIssueResolved,
+	CommentPullConflict,
+	CommentPullResolved,
+	IssueComments,
+	IssueTransitions,
+	Notifications,
+	PullComments,
+}
+
+// Resolution is a setting's effective value for a user, and where it came from
+type Resolution struct {
+	Setting string      `json:"setting"`
+	Value   interface{} `json:"value"`
+	Layer   Layer       `json:"layer"`
+	// Key is the configuration key that the value was read from. It is empty for defaults.
+	Key string `json:"key,omitempty"`
+	// Team is the team whose settings the value was read from, for the team layer
+	Team string `json:"team,omitempty"`
+
+	store *viper.Viper
+}
+
+// Source describes where a resolution's value came from
+func (r Resolution) Source() string {
+
+	if r.Layer == LayerDefault {
+		return "default"
+	}
+
+	return fmt.Sprintf("%s setting '%s'", r.Layer, r.Key)
+}
+
+func (r Resolution) String() string {
+	return fmt.Sprintf("%s = %v (%s)", r.Setting, r.Value, r.Source())
+}
+
+// layerKey is the key a setting is read from in a layer
+type layerKey struct {
+	layer Layer
+	team  string
+	key   string
+}
+
+// Resolve resolves a setting's effective value for a user, explaining where it came from. The setting is read from
+// the first layer that explicitly sets it, in the order: enforced, user, team, global, default.
+func Resolve(user, setting string) Resolution {
+
+	r := Resolution{Setting: setting, store: viper.GetViper()}
+
+	candidates := []layerKey{{layer: LayerEnforced, key: scopedSettingName("enforce", setting)}}
+	if user != "" {
+		candidates = append(candidates, layerKey{layer: LayerUser, key: userSettingName(user, setting)})
+		for _, team := range TeamsOf(user) {
+			candidates = append(candidates, layerKey{layer: LayerTeam, team: team, key: teamSettingName(team, setting)})
+		}
+	}
+
+	for _, c := range candidates {
+		if viper.IsSet(c.key) {
+			r.Layer, r.Team, r.Key = c.layer, c.team, c.key
+			r.Value = viper.Get(c.key)
+			return r
+		}
+	}
+
+	if viper.IsSet(setting) {
+		r.Layer, r.Key = LayerGlobal, setting
+		r.Value = viper.Get(setting)
+		return r
+	}
+
+	r.Layer, r.store = LayerDefault, defaults
+	r.Value = defaults.Get(setting)
+
+	return r
+}
+
+// ResolveAll resolves every setting that may be configured per user, for the user
+func ResolveAll(user string) []Resolution {
+
+	var resolutions []Resolution
+	for _, setting := range UserSettings {
+		resolutions = append(resolutions, Resolve(user, setting))
+	}
+
+	return resolutions
+}
+
+func (r Resolution) bool() bool {
+	return r.store.GetBool(r.key())
+}
+
+func (r Resolution) string() string {
+	return r.store.GetString(r.key())
+}
+
+func (r Resolution) key() string {
+
+	if r.Key == "" {
+		return r.Setting
+	}
+
+	return r.Key
+}
+
+// store is where a setting's global value is read from: the configuration when it is set there, and defaults otherwise
+func store(setting string) *viper.Viper {
+
+	if viper.IsSet(setting) {
+		return viper.GetViper()
+	}
+
+	return defaults
+}
+
+func userSettingName(user, setting string) string {
+	return scopedSettingName("users."+user, setting)
+}
+
+func teamSettingName(team, setting string) string {
+	return scopedSettingName("teams."+team, setting)
+}
+
+// scopedSettingName names a setting within a scope, e.g. settings.issues.enable_comment within users.<login> is
+// users.<login>.settings.issues.enable_comment
+func scopedSettingName(scope, setting string) string {
+	return fmt.Sprintf("%s.%s%s", scope, settingsPrefix, strings.TrimPrefix(setting, settingsPrefix))
+}
@@ -0,0 +1,535 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the typed schema of config.yaml
+type Config struct {
+	// Extends is a configuration file that this configuration is layered over: a path, a URL, or a file in a Github
+	// repository, e.g. 'my-org/.github:prwatch.yaml'
+	Extends  string                `yaml:"extends"`
+	Settings Settings              `yaml:"settings"`
+	Enforce  EnforcedConfig        `yaml:"enforce"`
+	Teams    map[string]TeamConfig `yaml:"teams"`
+	Users    map[string]UserConfig `yaml:"users"`
+}
+
+// Settings are global settings
+type Settings struct {
+	Comments      CommentSettings      `yaml:"comments"`
+	DryRun        bool                 `yaml:"dry_run"`
+	DualPass      DualPassSettings     `yaml:"dual_pass"`
+	Git           GitSettings          `yaml:"git"`
+	Github        GithubSettings       `yaml:"github"`
+	Issues        IssueSettings        `yaml:"issues"`
+	Jira          JiraSettings         `yaml:"jira"`
+	Notifications NotificationSettings `yaml:"notifications"`
+	Pulls         PullSettings         `yaml:"pulls"`
+	Report        PathSettings         `yaml:"report"`
+	State         PathSettings         `yaml:"state"`
+}
+
+type CommentSettings struct {
+	IssueConflict string `yaml:"issue_conflict"`
+	IssueResolved string `yaml:"issue_resolved"`
+	PullConflict  string `yaml:"pull_conflict"`
+	PullResolved  string `yaml:"pull_resolved"`
+}
+
+type DualPassSettings struct {
+	Enabled      bool   `yaml:"enabled"`
+	PollInterval string `yaml:"poll_interval"`
+	WaitDuration string `yaml:"wait_duration"`
+}
+
+type GitSettings struct {
+	Concurrency  int    `yaml:"concurrency"`
+	FetchDepth   int    `yaml:"fetch_depth"`
+	MergeMode    string `yaml:"merge_mode"`
+	MergeUnknown bool   `yaml:"merge_unknown"`
+}
+
+type GithubSettings struct {
+	ConflictColumnID string `yaml:"conflict_column_id"`
+	ConflictLabel    string `yaml:"conflict_label"`
+}
+
+type IssueSettings struct {
+	ConflictStatus   string   `yaml:"conflict_status"`
+	EnableComment    bool     `yaml:"enable_comment"`
+	EnableTransition bool     `yaml:"enable_transition"`
+	Patterns         []string `yaml:"patterns"`
+	ProjectKeys      []string `yaml:"project_keys"`
+	Provider         string   `yaml:"provider"`
+	ResolvedStatus   string   `yaml:"resolved_status"`
+	Sources          []string `yaml:"sources"`
+}
+
+type JiraSettings struct {
+	Enabled     bool                   `yaml:"enabled"`
+	Host        string                 `yaml:"host"`
+	ProjectName string                 `yaml:"project_name"`
+	Transitions JiraTransitionSettings `yaml:"transitions"`
+	User        string                 `yaml:"user"`
+}
+
+type JiraTransitionSettings struct {
+	AllowFrom []string          `yaml:"allow_from"`
+	DenyFrom  []string          `yaml:"deny_from"`
+	StatusMap map[string]string `yaml:"status_map"`
+}
+
+type NotificationSettings struct {
+	Email   EmailSettings    `yaml:"email"`
+	Enabled bool             `yaml:"enabled"`
+	Slack   NotifierSettings `yaml:"slack"`
+	Teams   NotifierSettings `yaml:"teams"`
+	Webhook NotifierSettings `yaml:"webhook"`
+}
+
+type NotifierSettings struct {
+	Mode string `yaml:"mode"`
+}
+
+type EmailSettings struct {
+	From     string `yaml:"from"`
+	Host     string `yaml:"host"`
+	Mode     string `yaml:"mode"`
+	Port     int    `yaml:"port"`
+	Subject  string `yaml:"subject"`
+	Template string `yaml:"template"`
+	User     string `yaml:"user"`
+}
+
+type PullSettings struct {
+	EnableComment bool               `yaml:"enable_comment"`
+	Exclude       PullExcludeFilters `yaml:"exclude"`
+	Include       PullIncludeFilters `yaml:"include"`
+	MaxAge        string             `yaml:"max_age"`
+}
+
+// PullIncludeFilters restrict the pull requests that are watched to those that match
+type PullIncludeFilters struct {
+	Authors []string `yaml:"authors"`
+	Bases   []string `yaml:"bases"`
+	Labels  []string `yaml:"labels"`
+}
+
+// PullExcludeFilters exclude matching pull requests from being watched
+type PullExcludeFilters struct {
+	Authors []string `yaml:"authors"`
+	Bases   []string `yaml:"bases"`
+	Bots    bool     `yaml:"bots"`
+	Drafts  bool     `yaml:"drafts"`
+	Labels  []string `yaml:"labels"`
+}
+
+type PathSettings struct {
+	Path string `yaml:"path"`
+}
+
+// LayeredSettings are the settings that may be configured per team and user. Unset settings are nil.
+type LayeredSettings struct {
+	Comments CommentSettings `yaml:"comments"`
+	Issues   struct {
+		EnableComment    *bool `yaml:"enable_comment"`
+		EnableTransition *bool `yaml:"enable_transition"`
+	} `yaml:"issues"`
+	Notifications struct {
+		Enabled *bool `yaml:"enabled"`
+	} `yaml:"notifications"`
+	Pulls struct {
+		EnableComment *bool `yaml:"enable_comment"`
+	} `yaml:"pulls"`
+}
+
+// EnforcedConfig are settings that override every team's and user's settings
+type EnforcedConfig struct {
+	Settings LayeredSettings `yaml:"settings"`
+}
+
+// TeamConfig is a team's configuration, whose settings apply to each of its members
+type TeamConfig struct {
+	Github   string          `yaml:"github"`
+	Members  []string        `yaml:"members"`
+	Settings LayeredSettings `yaml:"settings"`
+}
+
+// UserConfig is a user's configuration, keyed by their Github login
+type UserConfig struct {
+	Handles  map[string]string `yaml:"handles"`
+	Settings LayeredSettings   `yaml:"settings"`
+}
+
+// defaultConfig is the configuration that config.yaml is layered over
+func defaultConfig() Config {
+
+	var c Config
+
+	s := &c.Settings
+	s.DualPass = DualPassSettings{Enabled: true, PollInterval: "2s", WaitDuration: "60s"}
+	s.Git = GitSettings{Concurrency: 4, MergeMode: GitMergeModeWorktree}
+	s.Issues.EnableComment = true
+	s.Issues.EnableTransition = true
+	s.Issues.Provider = IssueProviderJira
+	s.Jira.Enabled = true
+	s.Jira.Transitions.DenyFrom = []string{"Archived", "Done", "Released", "Backlog"}
+	s.Notifications.Enabled = true
+	s.Notifications.Email.Mode = NotificationModeDigest
+	s.Notifications.Email.Port = 25
+
+	return c
+}
+
+// configFile is a parsed configuration file: its typed configuration, the line of each of its settings, and the
+// problems found while parsing it
+type configFile struct {
+	config   Config
+	lines    map[string]int
+	problems []string
+}
+
+// loaded is the configuration file that was last loaded
+var loaded *configFile
+
+// parseConfig parses a configuration file over a base configuration, reporting unknown settings and values of the
+// wrong type by line
+func parseConfig(base Config, b []byte) *configFile {
+
+	f := &configFile{config: base, lines: map[string]int{}}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		f.problems = append(f.problems, strings.TrimPrefix(err.Error(), "yaml: "))
+		return f
+	}
+
+	if len(doc.Content) == 0 {
+		return f
+	}
+
+	f.problems = append(f.problems, f.unknownSettings(doc.Content[0], reflect.TypeOf(f.config), "")...)
+
+	if err := doc.Content[0].Decode(&f.config); err != nil {
+		if terr, ok := err.(*yaml.TypeError); ok {
+			for _, e := range terr.Errors {
+				f.problems = append(f.problems, f.typeProblem(e))
+			}
+		} else {
+			f.problems = append(f.problems, err.Error())
+		}
+	}
+
+	return f
+}
+
+// unknownSettings records the line of every setting in node, and reports those that are not part of t
+func (f *configFile) unknownSettings(node *yaml.Node, t reflect.Type, path string) (problems []string) {
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+
+		name := key.Value
+		if path != "" {
+			name = path + "." + key.Value
+		}
+
+		f.lines[name] = key.Line
+
+		switch t.Kind() {
+		case reflect.Struct:
+			field, ok := fieldByTag(t, key.Value)
+			if !ok {
+				problems = append(problems, fmt.Sprintf("line %d: unknown setting '%s'", key.Line, name))
+				continue
+			}
+
+			problems = append(problems, f.unknownSettings(value, field.Type, name)...)
+		case reflect.Map:
+			problems = append(problems, f.unknownSettings(value, t.Elem(), name)...)
+		}
+	}
+
+	return
+}
+
+func fieldByTag(t reflect.Type, tag string) (reflect.StructField, bool) {
+
+	for i := 0; i < t.NumField(); i++ {
+		if strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0] == tag {
+			return t.Field(i), true
+		}
+	}
+
+	return reflect.StructField{}, false
+}
+
+// problem describes a problem with a setting, at its line when it is in the configuration file, or otherwise the line
+// of its closest parent that is
+func (f *configFile) problem(setting, details string) string {
+
+	name := setting
+	for name != "" {
+		if line, ok := f.lines[name]; ok {
+			return fmt.Sprintf("line %d: %s", line, CheckMessage(setting, details))
+		}
+
+		i := strings.LastIndex(name, ".")
+		if i < 0 {
+			break
+		}
+
+		name = name[:i]
+	}
+
+	return CheckMessage(setting, details)
+}
+
+// typeLine matches the line that yaml type errors occur on
+var typeLine = regexp.MustCompile(`^line (\d+): `)
+
+// typeProblem names the setting that a yaml type error, e.g. "line 5: cannot unmarshal !!str `lots` into int",
+// occurred for
+func (f *configFile) typeProblem(e string) string {
+
+	m := typeLine.FindStringSubmatch(e)
+	if m == nil {
+		return e
+	}
+
+	line, _ := strconv.Atoi(m[1])
+
+	var setting string
+	for name, l := range f.lines {
+		if l == line && len(name) > len(setting) {
+			setting = name
+		}
+	}
+
+	if setting == "" {
+		return e
+	}
+
+	return fmt.Sprintf("line %d: %s", line, CheckMessage(setting, strings.TrimPrefix(e, m[0])+"."))
+}
+
+// problemLine is the line that a problem occurs on, or 0 when it is not on a line
+func problemLine(problem string) int {
+
+	m := typeLine.FindStringSubmatch(problem)
+	if m == nil {
+		return 0
+	}
+
+	line, _ := strconv.Atoi(m[1])
+
+	return line
+}
+
+// validate checks the configuration for settings that prwatch cannot run with
+func (f *configFile) validate() (problems []string) {
+
+	s := f.config.Settings
+
+	durations := map[string]string{DualPassPollInterval: s.DualPass.PollInterval, DualPassWaitDuration: s.DualPass.WaitDuration}
+	for _, setting := range []string{DualPassPollInterval, DualPassWaitDuration} {
+		if _, err := time.ParseDuration(durations[setting]); err != nil {
+			problems = append(problems, f.problem(setting, "e.g. '1m30s'"))
+		}
+	}
+
+	if s.Pulls.MaxAge != "" {
+		if d, err := time.ParseDuration(s.Pulls.MaxAge); err != nil || d <= 0 {
+			problems = append(problems, f.problem(PullMaxAge, "Must be a positive duration, e.g. '720h'."))
+		}
+	}
+
+	globs := map[string][]string{PullIncludeBases: s.Pulls.Include.Bases, PullExcludeBases: s.Pulls.Exclude.Bases}
+	for _, setting := range []string{PullIncludeBases, PullExcludeBases} {
+		for _, glob := range globs[setting] {
+			if _, err := path.Match(glob, ""); err != nil {
+				problems = append(problems, f.problem(setting, fmt.Sprintf("Invalid pattern: '%s'.", glob)))
+			}
+		}
+	}
+
+	if s.Git.Concurrency < 1 {
+		problems = append(problems, f.problem(GitConcurrency, "Must be a positive number."))
+	}
+
+	if s.Git.FetchDepth < 0 {
+		problems = append(problems, f.problem(GitFetchDepth, "Must be zero, to fetch all history, or a positive number."))
+	}
+
+	switch s.Git.MergeMode {
+	case GitMergeModeMergeTree, GitMergeModeWorktree:
+	default:
+		problems = append(problems, f.problem(GitMergeMode, fmt.Sprintf("Must be one of '%s' or '%s'.", GitMergeModeWorktree, GitMergeModeMergeTree)))
+	}
+
+	modes := map[string]string{
+		"email":   s.Notifications.Email.Mode,
+		"slack":   s.Notifications.Slack.Mode,
+		"teams":   s.Notifications.Teams.Mode,
+		"webhook": s.Notifications.Webhook.Mode,
+	}
+
+	for _, name := range Notifiers {
+		switch modes[name] {
+		case "", NotificationModeAlert, NotificationModeDigest:
+		default:
+			setting := fmt.Sprintf(NotificationModeFormat, name)
+			problems = append(problems, f.problem(setting, fmt.Sprintf("Must be one of '%s' or '%s'.", NotificationModeAlert, NotificationModeDigest)))
+		}
+	}
+
+	if s.Notifications.Email.Host != "" && s.Notifications.Email.From == "" {
+		problems = append(problems, f.problem(EmailFrom, "Required when email notifications are enabled."))
+	}
+
+	problems = append(problems, f.validateTemplate(EmailSubject, s.Notifications.Email.Subject)...)
+	problems = append(problems, f.validateTemplate(EmailTemplate, s.Notifications.Email.Template)...)
+	problems = append(problems, f.validateComments("", s.Comments)...)
+	problems = append(problems, f.validateComments("enforce", f.config.Enforce.Settings.Comments)...)
+
+	var teams []string
+	for team := range f.config.Teams {
+		teams = append(teams, team)
+	}
+
+	sort.Strings(teams)
+
+	for _, team := range teams {
+		t := f.config.Teams[team]
+		if len(t.Members) == 0 && t.Github == "" {
+			problems = append(problems, f.problem("teams."+team, "Teams must list their members, or name a Github team with 'github'."))
+		}
+
+		if parts := strings.Split(t.Github, "/"); t.Github != "" && (len(parts) > 2 || parts[len(parts)-1] == "" || parts[0] == "") {
+			problems = append(problems, f.problem("teams."+team+".github", "Must be a Github team, e.g. 'org/team-slug'."))
+		}
+
+		problems = append(problems, f.validateComments("teams."+team, t.Settings.Comments)...)
+	}
+
+	for _, user := range sortedKeys(f.config.Users) {
+		problems = append(problems, f.validateComments("users."+user, f.config.Users[user].Settings.Comments)...)
+	}
+
+	for _, source := range s.Issues.Sources {
+		switch strings.ToLower(source) {
+		case IssueSourceBody, IssueSourceBranch, IssueSourceTitle:
+		default:
+			problems = append(problems, f.problem(IssueSources, fmt.Sprintf("Unknown source '%s'. Must be any of '%s', '%s' or '%s'.", source, IssueSourceTitle, IssueSourceBranch, IssueSourceBody)))
+		}
+	}
+
+	for _, pattern := range s.Issues.Patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			problems = append(problems, f.problem(IssuePatterns, fmt.Sprintf("Invalid pattern '%s': %v.", pattern, err)))
+		}
+	}
+
+	switch s.Issues.Provider {
+	case IssueProviderJira:
+		if !s.Jira.Enabled {
+			problems = append(problems, f.problem(Jira, "Jira must be enabled when it is the issue provider."))
+			break
+		}
+
+		required := map[string]string{JiraHost: s.Jira.Host, JiraUser: s.Jira.User}
+		for _, setting := range []string{JiraHost, JiraUser} {
+			if required[setting] == "" {
+				problems = append(problems, f.problem(setting, "Required when Jira is the issue provider."))
+			}
+		}
+
+		// issue keys are matched with the project name, unless project keys or patterns are configured
+		if s.Jira.ProjectName == "" && len(s.Issues.ProjectKeys) == 0 && len(s.Issues.Patterns) == 0 {
+			problems = append(problems, f.problem(JiraProjectName, fmt.Sprintf("Required when Jira is the issue provider, unless '%s' or '%s' are configured.", IssueProjectKeys, IssuePatterns)))
+		}
+	case IssueProviderGithub, IssueProviderNone:
+	default:
+		problems = append(problems, f.problem(IssueProvider, fmt.Sprintf("Must be one of '%s', '%s' or '%s'.", IssueProviderJira, IssueProviderGithub, IssueProviderNone)))
+	}
+
+	return
+}
+
+// validateComments checks the comment templates configured within scope, e.g. users.<login>, or globally when scope is
+// empty
+func (f *configFile) validateComments(scope string, c CommentSettings) (problems []string) {
+
+	templates := map[string]string{
+		CommentIssueConflict: c.IssueConflict,
+		CommentIssueResolved: c.IssueResolved,
+		CommentPullConflict:  c.PullConflict,
+		CommentPullResolved:  c.PullResolved,
+	}
+
+	for _, setting := range commentTemplates {
+		name := setting
+		if scope != "" {
+			name = scopedSettingName(scope, setting)
+		}
+
+		problems = append(problems, f.validateTemplate(name, templates[setting])...)
+	}
+
+	return
+}
+
+func (f *configFile) validateTemplate(setting, text string) (problems []string) {
+
+	if _, err := template.New(setting).Funcs(templateFuncs).Parse(text); err != nil {
+		problems = append(problems, f.problem(setting, fmt.Sprintf("Invalid template: %v.", err)))
+	}
+
+	return
+}
+
+// defaultSettings encodes the default configuration as yaml, for reading into viper
+func defaultSettings() *bytes.Buffer {
+
+	var b bytes.Buffer
+	enc := yaml.NewEncoder(&b)
+	if err := enc.Encode(struct {
+		Settings Settings `yaml:"settings"`
+	}{defaultConfig().Settings}); err != nil {
+		panic(err)
+	}
+
+	return &b
+}
+
+func sortedKeys(users map[string]UserConfig) []string {
+
+	var keys []string
+	for k := range users {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
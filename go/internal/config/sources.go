@@ -0,0 +1,241 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigPaths are the paths that the configuration file is searched for at, in order, both locally and on the
+// repository's default branch
+var ConfigPaths = []string{
+	".github/prwatch.yaml",
+	".github/prwatch.yml",
+	"github-actions/prwatch-action/config.yaml",
+	"github-actions/prwatch-action/config.yml",
+}
+
+// maxExtends is the most configuration files that may be layered with 'extends'
+const maxExtends = 5
+
+// sourceTimeout is how long reading configuration from a URL may take
+const sourceTimeout = 10 * time.Second
+
+// sourceClient reads configuration from URLs
+var sourceClient = &http.Client{Timeout: sourceTimeout}
+
+// RepositoryFileReader reads a file from a Github repository's default branch, e.g. 'owner/name', returning nil when
+// the file does not exist
+type RepositoryFileReader func(repository, path string) ([]byte, error)
+
+// repositoryFiles reads configuration files from Github repositories, when set
+var repositoryFiles RepositoryFileReader
+
+// repositoryReference matches 'extends' references to files in Github repositories, e.g. 'my-org/.github:prwatch.yaml'
+var repositoryReference = regexp.MustCompile(`^([\w.-]+/[\w.-]+):(.+)$`)
+
+// SetRepositoryFileReader sets how configuration is read from Github repositories: from the default branch when no
+// configuration file is found locally, and from other repositories that configuration extends
+func SetRepositoryFileReader(r RepositoryFileReader) {
+	repositoryFiles = r
+}
+
+// configSource is where a configuration file is read from: a local file, a file in a Github repository, or a URL
+type configSource struct {
+	repository string
+	path       string
+	url        string
+}
+
+func (s configSource) String() string {
+
+	switch {
+	case s.url != "":
+		return s.url
+	case s.repository != "":
+		return fmt.Sprintf("%s:%s", s.repository, s.path)
+	default:
+		return s.path
+	}
+}
+
+func (s configSource) read() ([]byte, error) {
+
+	switch {
+	case s.url != "":
+		return readURL(s.url)
+	case s.repository != "":
+		if repositoryFiles == nil {
+			return nil, fmt.Errorf("unable to read '%s': configuration cannot be read from Github repositories", s)
+		}
+
+		b, err := repositoryFiles(s.repository, s.path)
+		if err == nil && b == nil {
+			err = fmt.Errorf("'%s' does not exist", s)
+		}
+
+		return b, err
+	default:
+		return ioutil.ReadFile(s.path)
+	}
+}
+
+// resolve resolves a reference to a configuration file: an https URL, a file in a Github repository, e.g.
+// 'owner/name:path', or a path. Paths are relative to s, and in s's repository when s is in a Github repository.
+// Configuration is only read from URLs over https, since it controls where comments and notifications are sent.
+func (s configSource) resolve(ref string) (configSource, error) {
+
+	if strings.HasPrefix(ref, "https://") {
+		return configSource{url: ref}, nil
+	}
+
+	if strings.HasPrefix(ref, "http://") {
+		return configSource{}, fmt.Errorf("unable to read '%s': configuration may only be read from https URLs", ref)
+	}
+
+	if m := repositoryReference.FindStringSubmatch(ref); m != nil {
+		return configSource{repository: m[1], path: m[2]}, nil
+	}
+
+	switch {
+	case s.url != "" || filepath.IsAbs(ref):
+		return configSource{path: ref}, nil
+	case s.repository != "":
+		return configSource{repository: s.repository, path: path.Join(path.Dir(s.path), ref)}, nil
+	default:
+		return configSource{path: filepath.Join(filepath.Dir(s.path), ref)}, nil
+	}
+}
+
+func readURL(url string) ([]byte, error) {
+
+	resp, err := sourceClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("unable to read '%s': %s", url, resp.Status)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// findConfig finds the configuration file: the file referenced by ref when it is given, which may be anything that can
+// be extended, or the first of ConfigPaths found locally, or on the repository's default branch
+func findConfig(ref string) (s configSource, b []byte, err error) {
+
+	if ref != "" {
+		if s, err = (configSource{}).resolve(ref); err != nil {
+			return
+		}
+
+		b, err = s.read()
+		return
+	}
+
+	for _, dir := range []string{".", "..", "../.."} {
+		for _, p := range ConfigPaths {
+			s = configSource{path: filepath.Join(dir, p)}
+			if _, serr := os.Stat(s.path); serr == nil {
+				b, err = s.read()
+				return
+			}
+		}
+	}
+
+	repository := GetEnv("GITHUB_REPOSITORY")
+	if repositoryFiles != nil && repository != "" {
+		for _, p := range ConfigPaths {
+			s = configSource{repository: repository, path: p}
+			if b, err = repositoryFiles(repository, p); err != nil || b != nil {
+				return
+			}
+		}
+	}
+
+	err = fmt.Errorf("no configuration file found at: %s, locally or on the default branch", strings.Join(ConfigPaths, ", "))
+
+	return
+}
+
+// readConfig reads a configuration file and every file it extends, returning them base first
+func readConfig(s configSource, b []byte) (sources []configSource, files [][]byte, err error) {
+
+	seen := map[string]bool{}
+
+	for {
+		if seen[s.String()] {
+			return nil, nil, fmt.Errorf("'%s' extends itself", s)
+		}
+
+		seen[s.String()] = true
+		sources = append([]configSource{s}, sources...)
+		files = append([][]byte{b}, files...)
+
+		var c struct {
+			Extends string `yaml:"extends"`
+		}
+
+		// syntax errors are reported when the file is parsed
+		if yaml.Unmarshal(b, &c) != nil || c.Extends == "" {
+			return
+		}
+
+		if len(sources) == maxExtends {
+			return nil, nil, fmt.Errorf("too many configuration files are extended from '%s'. At most %d may be layered", sources[len(sources)-1], maxExtends)
+		}
+
+		if s, err = s.resolve(c.Extends); err != nil {
+			return nil, nil, err
+		}
+
+		if b, err = s.read(); err != nil {
+			return nil, nil, fmt.Errorf("unable to read '%s', which is extended: %v", s, err)
+		}
+	}
+}
+
+// mergeConfig merges configuration files into viper, each over the last, and parses them into a typed configuration.
+// Problems in extended files are reported with the file they are in.
+func mergeConfig(sources []configSource, files [][]byte) (*configFile, error) {
+
+	f := &configFile{config: defaultConfig(), lines: map[string]int{}}
+
+	for i, b := range files {
+		merge := viper.MergeConfig
+		if i == 0 {
+			merge = viper.ReadConfig
+		}
+
+		if err := merge(bytes.NewReader(b)); err != nil {
+			return nil, fmt.Errorf("unable to read '%s': %v", sources[i], err)
+		}
+
+		parsed := parseConfig(f.config, b)
+		f.config = parsed.config
+
+		if i == len(files)-1 {
+			f.lines = parsed.lines
+			f.problems = append(f.problems, parsed.problems...)
+			continue
+		}
+
+		for _, p := range parsed.problems {
+			f.problems = append(f.problems, fmt.Sprintf("%s: %s", sources[i], p))
+		}
+	}
+
+	return f, nil
+}
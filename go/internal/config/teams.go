@@ -0,0 +1,111 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// Team is a team configured under teams.<name>, whose settings apply to each of its members
+type Team struct {
+	Name string
+	// Members are the team's statically listed members, from teams.<name>.members
+	Members []string
+	// Github is the Github team whose members are also members of the team, from teams.<name>.github, e.g.
+	// 'org/team-slug'. The organization defaults to the repository's owner.
+	Github string
+}
+
+// fetchedMembers are the members of teams found on Github, by team
+var fetchedMembers = struct {
+	sync.RWMutex
+	m map[string][]string
+}{m: map[string][]string{}}
+
+// Teams returns the configured teams, in the order they appear in the configuration file
+func Teams() []Team {
+
+	var teams []Team
+	for name := range viper.GetStringMap("teams") {
+		teams = append(teams, Team{
+			Name:    name,
+			Members: viper.GetStringSlice(fmt.Sprintf("teams.%s.members", name)),
+			Github:  viper.GetString(fmt.Sprintf("teams.%s.github", name)),
+		})
+	}
+
+	sort.Slice(teams, func(i, j int) bool {
+		li, lj := teamLine(teams[i].Name), teamLine(teams[j].Name)
+		if li != lj {
+			return li < lj
+		}
+
+		return teams[i].Name < teams[j].Name
+	})
+
+	return teams
+}
+
+// teamLine is the line that a team is configured on. Team names are lower case in viper, but not in the file.
+func teamLine(name string) int {
+
+	if loaded == nil {
+		return 0
+	}
+
+	for team := range loaded.config.Teams {
+		if strings.EqualFold(team, name) {
+			return loaded.lines["teams."+team]
+		}
+	}
+
+	return 0
+}
+
+// SetTeamMembers sets the members of a team found on Github
+func SetTeamMembers(team string, members []string) {
+
+	fetchedMembers.Lock()
+	defer fetchedMembers.Unlock()
+
+	fetchedMembers.m[strings.ToLower(team)] = members
+}
+
+// TeamsOf returns the teams that a user is a member of, statically or on Github, in the order they are configured.
+// When more than one of a user's teams configures a setting, the first team's setting applies.
+func TeamsOf(user string) (teams []string) {
+
+	fetchedMembers.RLock()
+	defer fetchedMembers.RUnlock()
+
+	for _, team := range Teams() {
+		if isMember(team.Members, user) || isMember(fetchedMembers.m[team.Name], user) {
+			teams = append(teams, team.Name)
+		}
+	}
+
+	return
+}
+
+func isMember(members []string, user string) bool {
+
+	for _, member := range members {
+		if strings.EqualFold(member, user) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resetTeamMembers forgets the members of teams found on Github
+func resetTeamMembers() {
+
+	fetchedMembers.Lock()
+	defer fetchedMembers.Unlock()
+
+	fetchedMembers.m = map[string][]string{}
+}
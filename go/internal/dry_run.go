@@ -0,0 +1,200 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/acaloiaro/prwatch/internal/config"
+)
+
+// dryRunRecorder is an issueProvider and pullCommenter that reports the transitions and comments prwatch would make,
+// without making them. It is swapped in for the configured providers when settings.dry_run is enabled.
+type dryRunRecorder struct {
+	out io.Writer
+}
+
+func newDryRunRecorder(out io.Writer) *dryRunRecorder {
+	return &dryRunRecorder{out: out}
+}
+
+// TransitionIssue reports the status an issue would be transitioned to
+func (r *dryRunRecorder) TransitionIssue(i issue) (ok bool) {
+
+	if !config.UserSettingEnabled(i.Owner, config.IssueTransitions) {
+		r.record("would not transition issue '%s': transitions are disabled for '%s'", i.ID, i.Owner)
+		return
+	}
+
+	if config.GetString(config.IssueProvider) == config.IssueProviderGithub {
+		r.record("would transition issue '%s' to '%s'", i.ID, r.conflictStatus())
+		return true
+	}
+
+	// Jira transitions depend on the issue's current status, which is not known without fetching the issue
+	status := r.conflictStatus()
+	statusMap := config.GetStringMapString(config.JiraTransitionMap)
+	if status == "" && len(statusMap) == 0 {
+		r.record("would not transition issue '%s': no conflict status is configured. %s", i.ID, config.CheckMessage(config.IssueConflictStatus))
+		return
+	}
+
+	r.record("would transition issue '%s' to '%s'%s", i.ID, status, jiraTransitionConditions(statusMap))
+
+	return true
+}
+
+// jiraTransitionConditions describes how the status that a Jira issue is transitioned to depends on its current status,
+// according to settings.jira.transitions
+func jiraTransitionConditions(statusMap map[string]string) string {
+
+	var conditions []string
+	if allow := config.GetStringSlice(config.JiraTransitionAllow); len(allow) > 0 {
+		conditions = append(conditions, fmt.Sprintf("only from '%s'", strings.Join(allow, "', '")))
+	} else if deny := config.GetStringSlice(config.JiraTransitionDeny); len(deny) > 0 {
+		conditions = append(conditions, fmt.Sprintf("not from '%s'", strings.Join(deny, "', '")))
+	}
+
+	var from []string
+	for status := range statusMap {
+		from = append(from, status)
+	}
+
+	sort.Strings(from)
+
+	for _, status := range from {
+		conditions = append(conditions, fmt.Sprintf("from '%s' to '%s'", status, statusMap[status]))
+	}
+
+	if len(conditions) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf(", depending on its current status: %s", strings.Join(conditions, "; "))
+}
+
+// CommentIssue reports the comment that would be left on an issue
+func (r *dryRunRecorder) CommentIssue(i issue) (ok bool) {
+
+	if !config.UserSettingEnabled(i.Owner, config.IssueComments) {
+		r.record("would not comment on issue '%s': comments are disabled for '%s'", i.ID, i.Owner)
+		return
+	}
+
+	var status, statusChangeMsg string
+	if config.UserSettingEnabled(i.Owner, config.IssueTransitions) {
+		status = r.conflictStatus()
+		statusChangeMsg = statusChangedMessage(status)
+	}
+
+	r.record("would comment on issue '%s': %s", i.ID, issueConflictComment(newIssueCommentData(i, "@"+i.Owner, status, statusChangeMsg)))
+
+	return true
+}
+
+// ResolveIssue reports the transition and comment that would be made if the issue was previously in conflict
+func (r *dryRunRecorder) ResolveIssue(i issue) (ok bool) {
+
+	var status, statusChangeMsg string
+	transitions := config.UserSettingEnabled(i.Owner, config.IssueTransitions)
+
+	switch config.GetString(config.IssueProvider) {
+	case config.IssueProviderGithub:
+		if label := config.GetString(config.GithubConflictLabel); label != "" && transitions {
+			r.record("would remove label '%s' from issue '%s', if previously in conflict", label, i.ID)
+		}
+	default:
+		resolvedStatus := config.GetString(config.IssueResolvedStatus)
+		if resolvedStatus == "" {
+			return
+		}
+
+		if transitions {
+			status = resolvedStatus
+			statusChangeMsg = statusChangedMessage(resolvedStatus)
+			r.record("would transition issue '%s' to '%s', if previously in conflict", i.ID, resolvedStatus)
+		}
+	}
+
+	if config.UserSettingEnabled(i.Owner, config.IssueComments) {
+		r.record("would comment on issue '%s', if previously in conflict: %s", i.ID, issueResolvedComment(newIssueCommentData(i, "@"+i.Owner, status, statusChangeMsg)))
+	}
+
+	return true
+}
+
+// CommentPull reports the comment that would be left on a pull request
+func (r *dryRunRecorder) CommentPull(pr GithubPullRequest, files []ConflictFile) (ok bool) {
+
+	if !config.UserSettingEnabled(string(pr.Author.Login), config.PullComments) {
+		return
+	}
+
+	r.record("would comment on pull request '%d': %s", pr.Number, pullConflictComment(pr, files))
+
+	return true
+}
+
+// ResolvePull reports the comment that would be left on a pull request if it was previously in conflict
+func (r *dryRunRecorder) ResolvePull(pr GithubPullRequest) (ok bool) {
+
+	if !config.UserSettingEnabled(string(pr.Author.Login), config.PullComments) {
+		return
+	}
+
+	r.record("would comment on pull request '%d', if previously in conflict: %s", pr.Number, pullResolvedComment(pr))
+
+	return true
+}
+
+func (r *dryRunRecorder) conflictStatus() string {
+
+	if config.GetString(config.IssueProvider) == config.IssueProviderGithub {
+		return config.GetString(config.GithubConflictLabel)
+	}
+
+	return config.GetString(config.IssueConflictStatus)
+}
+
+// dryRunNotifier is a notifier that reports the alerts and digests that a notifier would send, without sending them
+type dryRunNotifier struct {
+	r    *dryRunRecorder
+	name string
+}
+
+func newDryRunNotifier(out io.Writer, name string) notifier {
+	return &dryRunNotifier{r: newDryRunRecorder(out), name: name}
+}
+
+// Name identifies the notifier that notifications would be sent with
+func (n *dryRunNotifier) Name() string {
+	return n.name
+}
+
+// Alert reports the alert that would be sent
+func (n *dryRunNotifier) Alert(a conflictAlert) error {
+
+	n.r.record("would send %s alert: %s", n.name, alertSummary(a, a.Handle, dryRunLink))
+
+	return nil
+}
+
+// Digest reports the digest that would be sent
+func (n *dryRunNotifier) Digest(alerts []conflictAlert) error {
+
+	n.r.record("would send %s digest: %s", n.name, digestTitle(alerts))
+	for _, a := range alerts {
+		n.r.record("  %s", alertSummary(a, a.Handle, dryRunLink))
+	}
+
+	return nil
+}
+
+func dryRunLink(url, text string) string {
+	return fmt.Sprintf("%s (%s)", text, url)
+}
+
+func (r *dryRunRecorder) record(format string, args ...interface{}) {
+	fmt.Fprintf(r.out, "[dry-run] "+format+"\n", args...)
+}
@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/acaloiaro/prwatch/internal/config"
+)
+
+func TestDryRunRecorder(t *testing.T) {
+
+	config.GlobalEnable(config.IssueTransitions)
+	config.GlobalEnable(config.IssueComments)
+	config.GlobalEnable(config.PullComments)
+	config.GlobalSet(config.IssueConflictStatus, "In Progress")
+	defer config.GlobalDisable(config.PullComments)
+
+	out := &bytes.Buffer{}
+	r := newDryRunRecorder(out)
+
+	files := []ConflictFile{{Path: "foo.go", Hunks: 2}, {Path: "bar.png"}}
+	i := issue{ID: "FOO-1", Owner: "acaloiaro", Files: files}
+	r.TransitionIssue(i)
+	r.CommentIssue(i)
+	r.CommentPull(GithubPullRequest{Number: 1, BaseRefName: "master", Author: actor{Login: "acaloiaro"}}, files)
+
+	expected := []string{
+		"[dry-run] would transition issue 'FOO-1' to 'In Progress'",
+		"[dry-run] would comment on issue 'FOO-1': @acaloiaro: This issue's pull request has a merge conflict. This issue's status has changed to: 'In Progress'.",
+		"[dry-run] would comment on pull request '1': @acaloiaro: This pull request has a merge conflict with 'master'.",
+		"Conflicting files:\n- foo.go (2 conflicts)\n- bar.png\n",
+	}
+
+	for _, e := range expected {
+		if !strings.Contains(out.String(), e) {
+			t.Errorf("expected dry run output to contain: %s\ngot: %s", e, out.String())
+		}
+	}
+}
+
+func TestDryRunJiraTransitions(t *testing.T) {
+
+	defer func() {
+		config.GlobalSet(config.IssueConflictStatus, "")
+		config.GlobalSetList(config.JiraTransitionAllow, nil)
+		config.GlobalSetMap(config.JiraTransitionMap, nil)
+	}()
+
+	config.GlobalEnable(config.IssueTransitions)
+	config.GlobalSet(config.IssueConflictStatus, "In Progress")
+	config.GlobalSetList(config.JiraTransitionAllow, []string{"In Review", "QA"})
+	config.GlobalSetMap(config.JiraTransitionMap, map[string]string{"qa": "Blocked"})
+
+	out := &bytes.Buffer{}
+	newDryRunRecorder(out).TransitionIssue(issue{ID: "FOO-1", Owner: "acaloiaro"})
+
+	expected := "[dry-run] would transition issue 'FOO-1' to 'In Progress', depending on its current status: only from 'In Review', 'QA'; from 'qa' to 'Blocked'"
+	if !strings.Contains(out.String(), expected) {
+		t.Errorf("expected dry run output to contain: %s\ngot: %s", expected, out.String())
+	}
+
+	config.GlobalSet(config.IssueConflictStatus, "")
+	config.GlobalSetMap(config.JiraTransitionMap, nil)
+
+	out.Reset()
+	if newDryRunRecorder(out).TransitionIssue(issue{ID: "FOO-1", Owner: "acaloiaro"}) {
+		t.Errorf("issues should not be transitioned without a conflict status, got: %s", out.String())
+	}
+}
+
+func TestDryRunServices(t *testing.T) {
+
+	defer services.reset()
+
+	config.GlobalEnable(config.DryRun)
+	defer config.GlobalDisable(config.DryRun)
+
+	if _, ok := services.issues().(*dryRunRecorder); !ok {
+		t.Error("issue provider should be a dry run recorder")
+	}
+
+	if _, ok := services.pulls().(*dryRunRecorder); !ok {
+		t.Error("pull commenter should be a dry run recorder")
+	}
+
+	config.SetEnv(slackWebhookEnv, "https://hooks.slack.com/services/T0/B0/X")
+	defer config.SetEnv(slackWebhookEnv, "")
+
+	notifiers := services.notifiers()
+	if len(notifiers) != 1 || notifiers[0].Name() != "slack" {
+		t.Fatalf("expected a slack notifier, got: %v", notifiers)
+	}
+
+	if _, ok := notifiers[0].(*dryRunNotifier); !ok {
+		t.Error("notifiers should be dry run notifiers")
+	}
+}
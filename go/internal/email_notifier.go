@@ -0,0 +1,172 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/acaloiaro/prwatch/internal/config"
+)
+
+// smtpPasswordEnv is the environment variable containing the password of settings.notifications.email.user
+const smtpPasswordEnv = "SMTP_PASSWORD"
+
+const defaultEmailSubject = `Merge conflicts in {{.Repository}}`
+
+const defaultEmailTemplate = `Hi {{.Author}},
+
+{{if eq (len .Pulls) 1}}Your pull request in {{.Repository}} has a merge conflict:{{else}}{{len .Pulls}} of your pull requests in {{.Repository}} have merge conflicts:{{end}}
+{{range .Pulls}}
+#{{.Number}} {{.Title}}
+{{.URL}}
+{{- if .IssueIDs}}
+Issues: {{join .IssueIDs ", "}}
+{{- end}}
+{{- range .Files}}
+Conflicting file: {{.Path}}
+{{- end}}
+{{end}}`
+
+// emailNotifier sends each author an email of their conflicting pull requests over SMTP. Authors' addresses are taken
+// from users.<login>.handles.email, and messages are rendered with settings.notifications.email.subject and
+// settings.notifications.email.template.
+type emailNotifier struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+// emailData is the data that email subject and body templates are executed with
+type emailData struct {
+	Repository string
+	Author     string
+	Address    string
+	Pulls      []conflictAlert
+}
+
+func newEmailNotifier() notifier {
+
+	host := config.GetString(config.EmailHost)
+
+	n := &emailNotifier{
+		addr: net.JoinHostPort(host, config.GetString(config.EmailPort)),
+		from: config.GetString(config.EmailFrom),
+	}
+
+	if user := config.GetString(config.EmailUser); user != "" {
+		n.auth = smtp.PlainAuth("", user, config.GetEnv(smtpPasswordEnv), host)
+	}
+
+	return n
+}
+
+// Name identifies email notifications
+func (e *emailNotifier) Name() string {
+	return "email"
+}
+
+// Alert emails a single pull request's conflict to its author
+func (e *emailNotifier) Alert(a conflictAlert) error {
+	return e.Digest([]conflictAlert{a})
+}
+
+// Digest emails each author a single message listing all of their conflicting pull requests. Authors without an
+// address are skipped.
+func (e *emailNotifier) Digest(alerts []conflictAlert) error {
+
+	var authors []string
+	byAuthor := map[string][]conflictAlert{}
+
+	for _, a := range alerts {
+		if a.Handle == "" {
+			log.Printf("no email address for '%s', skipping. %s", a.Author, config.CheckMessage(fmt.Sprintf("users.%s.handles.email", a.Author)))
+			continue
+		}
+
+		if _, ok := byAuthor[a.Author]; !ok {
+			authors = append(authors, a.Author)
+		}
+
+		byAuthor[a.Author] = append(byAuthor[a.Author], a)
+	}
+
+	var failed []string
+	for _, author := range authors {
+		pulls := byAuthor[author]
+		data := emailData{
+			Repository: config.GetEnv("GITHUB_REPOSITORY"),
+			Author:     author,
+			Address:    pulls[0].Handle,
+			Pulls:      pulls,
+		}
+
+		if err := e.send(data); err != nil {
+			log.Printf("unable to email '%s': %v", data.Address, err)
+			failed = append(failed, data.Address)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("unable to email: %s", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+func (e *emailNotifier) send(data emailData) (err error) {
+
+	subject, err := renderEmailTemplate(config.EmailSubject, defaultEmailSubject, data)
+	if err != nil {
+		return
+	}
+
+	body, err := renderEmailTemplate(config.EmailTemplate, defaultEmailTemplate, data)
+	if err != nil {
+		return
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", data.Address)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", emailSubject(subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.WriteString(strings.Replace(strings.Replace(body, "\r\n", "\n", -1), "\n", "\r\n", -1))
+
+	return smtp.SendMail(e.addr, e.auth, e.from, []string{data.Address}, msg.Bytes())
+}
+
+// emailSubject collapses a rendered subject onto a single line, so that it cannot add headers, and encodes it when it is
+// not ASCII
+func emailSubject(subject string) string {
+
+	return mime.QEncoding.Encode("utf-8", strings.Join(strings.Fields(subject), " "))
+}
+
+// renderEmailTemplate renders the template configured by setting, or defaultTemplate when it is not configured
+func renderEmailTemplate(setting, defaultTemplate string, data emailData) (string, error) {
+
+	text := config.GetString(setting)
+	if text == "" {
+		text = defaultTemplate
+	}
+
+	t, err := template.New(setting).Funcs(TemplateFuncs).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid template: %v. %s", err, config.CheckMessage(setting))
+	}
+
+	var b strings.Builder
+	if err = t.Execute(&b, data); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
@@ -0,0 +1,209 @@
+package internal
+
+import (
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/acaloiaro/prwatch/internal/config"
+)
+
+// smtpMessage is a message received by smtpServer
+type smtpMessage struct {
+	from string
+	to   []string
+	data string
+}
+
+// smtpServer is a minimal local SMTP server that records the messages it receives
+type smtpServer struct {
+	l        net.Listener
+	mu       sync.Mutex
+	messages []smtpMessage
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &smtpServer{l: l}
+	go s.serve()
+
+	return s
+}
+
+func (s *smtpServer) serve() {
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+
+		go s.handle(conn)
+	}
+}
+
+func (s *smtpServer) handle(conn net.Conn) {
+
+	defer conn.Close()
+
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 localhost ESMTP")
+
+	var m smtpMessage
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch verb {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250 localhost")
+		case "MAIL":
+			m = smtpMessage{from: strings.Trim(strings.TrimPrefix(line, "MAIL FROM:"), "<>")}
+			tp.PrintfLine("250 OK")
+		case "RCPT":
+			m.to = append(m.to, strings.Trim(strings.TrimPrefix(line, "RCPT TO:"), "<>"))
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 send data")
+			b, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+
+			m.data = string(b)
+
+			s.mu.Lock()
+			s.messages = append(s.messages, m)
+			s.mu.Unlock()
+
+			tp.PrintfLine("250 OK")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("250 OK")
+		}
+	}
+}
+
+func (s *smtpServer) received() []smtpMessage {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.messages
+}
+
+// configure configures email notifications to be sent to the server, returning a func that unconfigures them
+func (s *smtpServer) configure() (reset func()) {
+
+	host, port, _ := net.SplitHostPort(s.l.Addr().String())
+	config.GlobalSet(config.EmailHost, host)
+	config.GlobalSet(config.EmailPort, port)
+	config.GlobalSet(config.EmailFrom, "prwatch@example.com")
+
+	return func() {
+		for _, setting := range []string{config.EmailHost, config.EmailPort, config.EmailFrom, config.EmailSubject, config.EmailTemplate} {
+			config.GlobalSet(setting, "")
+		}
+	}
+}
+
+func TestEmailNotifierDigest(t *testing.T) {
+
+	server := newSMTPServer(t)
+	defer server.l.Close()
+	defer server.configure()()
+
+	config.SetEnv("GITHUB_REPOSITORY", "acaloiaro/isok")
+
+	alerts := []conflictAlert{
+		{Number: 1, Title: "Add foo", URL: "https://github.com/acaloiaro/isok/pull/1", Author: "acaloiaro", Handle: "adriano@example.com", IssueIDs: []string{"FOO-1"}},
+		{Number: 2, Title: "Add bar", URL: "https://github.com/acaloiaro/isok/pull/2", Author: "other", Handle: "other@example.com"},
+		{Number: 3, Title: "Add baz", URL: "https://github.com/acaloiaro/isok/pull/3", Author: "acaloiaro", Handle: "adriano@example.com"},
+		{Number: 4, Title: "Add qux", URL: "https://github.com/acaloiaro/isok/pull/4", Author: "nobody"},
+	}
+
+	if err := newEmailNotifier().Digest(alerts); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := server.received()
+	if len(messages) != 2 {
+		t.Fatalf("expected an email to each of 2 authors with addresses, got: %d", len(messages))
+	}
+
+	m := messages[0]
+	if m.from != "prwatch@example.com" || len(m.to) != 1 || m.to[0] != "adriano@example.com" {
+		t.Errorf("unexpected envelope: %+v", m)
+	}
+
+	expected := []string{
+		"Subject: Merge conflicts in acaloiaro/isok\n",
+		"2 of your pull requests in acaloiaro/isok have merge conflicts:",
+		"#1 Add foo\nhttps://github.com/acaloiaro/isok/pull/1\nIssues: FOO-1\n",
+		"#3 Add baz\nhttps://github.com/acaloiaro/isok/pull/3\n",
+	}
+
+	for _, e := range expected {
+		if !strings.Contains(m.data, e) {
+			t.Errorf("expected email to contain:\n%s\ngot:\n%s", e, m.data)
+		}
+	}
+
+	if strings.Contains(m.data, "Add bar") {
+		t.Error("authors should only be emailed their own pull requests")
+	}
+}
+
+func TestEmailNotifierTemplate(t *testing.T) {
+
+	server := newSMTPServer(t)
+	defer server.l.Close()
+	defer server.configure()()
+
+	config.GlobalSet(config.EmailSubject, "{{len .Pulls}} conflicts for {{.Author}}")
+	config.GlobalSet(config.EmailTemplate, "{{range .Pulls}}{{.URL}} ({{join .IssueIDs \"+\"}}){{end}}")
+
+	alert := conflictAlert{Number: 1, URL: "https://github.com/acaloiaro/isok/pull/1", Author: "acaloiaro", Handle: "adriano@example.com", IssueIDs: []string{"FOO-1", "FOO-2"}}
+	if err := newEmailNotifier().Alert(alert); err != nil {
+		t.Fatal(err)
+	}
+
+	m := server.received()[0]
+	if !strings.Contains(m.data, "Subject: 1 conflicts for acaloiaro\n") || !strings.HasSuffix(m.data, "https://github.com/acaloiaro/isok/pull/1 (FOO-1+FOO-2)\n") {
+		t.Errorf("email should have been rendered with the configured templates, got:\n%s", m.data)
+	}
+
+	// subjects are kept to one line and encoded, and templates have the same functions as comment templates
+	config.GlobalSet(config.EmailSubject, "{{range .Pulls}}{{.Title}}{{end}}")
+	config.GlobalSet(config.EmailTemplate, "{{range .Pulls}}{{conflictFiles .Files}}{{end}}")
+	alert.Title = "Café\r\nBcc: victim@example.com"
+	alert.Files = []ConflictFile{{Path: "foo.go"}}
+	if err := newEmailNotifier().Alert(alert); err != nil {
+		t.Fatal(err)
+	}
+
+	m = server.received()[1]
+	if !strings.Contains(m.data, "Subject: =?utf-8?q?Caf=C3=A9_Bcc:_victim@example.com?=\n") || strings.Contains(m.data, "\nBcc:") {
+		t.Errorf("subjects should be a single encoded line, got:\n%s", m.data)
+	}
+
+	if !strings.Contains(m.data, "- foo.go") {
+		t.Errorf("email templates should be able to list conflicting files, got:\n%s", m.data)
+	}
+
+	config.GlobalSet(config.EmailTemplate, "{{.Missing")
+	if err := newEmailNotifier().Alert(alert); err == nil {
+		t.Error("an invalid template should have been reported as an error")
+	}
+}
@@ -2,11 +2,12 @@
 package internal
 
 import (
+	"fmt"
 	"log"
-	"math"
 	"time"
 
 	"github.com/acaloiaro/prwatch/internal/config"
+	"github.com/shurcooL/githubv4"
 )
 
 type executor struct {
@@ -23,31 +24,24 @@ func NewExecutor(s executionPlan) *executor {
 // Execute executes the executionPlan in either one or two phases.
 // Dual phase mode is designed to retrieve Github's mergability status in two phases when this action is triggered on
 // merge to a base branch. Because immediately following a merge, Github cannot yet determine the mergability of pull
-// requests, the first phase is a request to Github to update its mergability statuses.
+// requests, the first phase is a request to Github to update its mergability statuses. Pull requests whose mergeable
+// state is still unknown are then polled with exponential backoff until every state is known, or
+// settings.dual_pass.wait_duration has elapsed.
 //
 // The second phase is to determine the actual mergability of all open pull requests.
 func (e *executor) Execute() error {
 
 	if config.SettingEnabled(config.DualPass) {
-		timer := e.executionPlan.DualPassTimer()
+		client := e.executionPlan.client()
 
 		// List open pull requests to trigger a refresh of Github's mergability status
-		ListPulls(e.executionPlan.client())
-
-		done := false
-		tick := time.Tick(1 * time.Second)
-		countdown := time.Now().Add(dualPassInterval())
-
-		for !done {
-			select {
-			case <-tick:
-				s := math.Round(countdown.Sub(time.Now()).Seconds())
-				log.Println("Waiting ...", s, "seconds")
-			case <-timer.C:
-				log.Println("Phase 1 complete.")
-				done = true
-			}
+		pulls, err := ListPulls(client)
+		if err != nil {
+			log.Println("Unable to fetch pull requests for repository: ", err)
 		}
+
+		waitForMergeability(client, pulls)
+		log.Println("Phase 1 complete.")
 	} else {
 		log.Println("Single pass mode")
 	}
@@ -55,67 +49,393 @@ func (e *executor) Execute() error {
 	return e.executionPlan.Execute()
 }
 
+// waitForMergeability polls Github for the mergeable state of pull requests whose state is unknown. Each poll only
+// re-queries the pull requests still unknown, and the interval between polls, starting at
+// settings.dual_pass.poll_interval, doubles after every poll. Polling stops when every pull request's state is known,
+// or settings.dual_pass.wait_duration has elapsed.
+func waitForMergeability(client GithubQueryer, pulls []GithubPullRequest) {
+
+	var unknown []int
+	for _, pr := range pulls {
+		if pr.Mergeable == githubv4.MergeableStateUnknown {
+			unknown = append(unknown, int(pr.Number))
+		}
+	}
+
+	interval := config.GetDuration(config.DualPassPollInterval)
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	deadline := time.Now().Add(dualPassInterval())
+
+	for len(unknown) > 0 {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			log.Printf("Github has not determined the mergeable state of %d pull requests after %s: %v",
+				len(unknown), dualPassInterval(), unknown)
+			return
+		}
+
+		if interval > remaining {
+			interval = remaining
+		}
+
+		log.Printf("Waiting %s for Github to determine the mergeable state of %d pull requests", interval, len(unknown))
+		time.Sleep(interval)
+
+		var stillUnknown []int
+		for _, number := range unknown {
+			state, err := pullMergeableState(client, number)
+			if err != nil {
+				log.Printf("unable to fetch mergeable state of pull request '%d': %v", number, err)
+			}
+
+			if err != nil || state == githubv4.MergeableStateUnknown {
+				stillUnknown = append(stillUnknown, number)
+			}
+		}
+
+		unknown = stillUnknown
+		interval *= 2
+	}
+}
+
 type executionPlan interface {
 	Execute() error
-	DualPassTimer() *time.Timer
 	client() GithubQueryer
 }
 
 // DefaultExecutionPlan is the executionPlan used by the main executable
 type DefaultExecutionPlan struct {
 	GithubClient GithubQueryer
+	// PullNumber restricts the plan to a single pull request. All open pull requests are checked when it is zero.
+	PullNumber int
+
+	report *Report
+}
+
+// pullAction is the action taken upon a pull request after checking it for conflicts
+type pullAction int
+
+const (
+	// actionNone: the pull request's state did not change
+	actionNone pullAction = iota
+	// actionNotify: the pull request became conflicting
+	actionNotify
+	// actionResolve: the pull request may have had its conflicts resolved
+	actionResolve
+)
+
+// pullCheck is the result of checking a single pull request for conflicts
+type pullCheck struct {
+	pull     GithubPullRequest
+	issueIDs []string
+	files    []ConflictFile
+	action   pullAction
+	state    pullState
+	// forget is whether nothing can be remembered about the pull request, i.e. its state is unknown
+	forget bool
+	// previous is the pull request's state before this run, which is kept when its conflict cannot be reported
+	previous pullState
+	known    bool
+	report   *PullReport
 }
 
 // Execute executes an executionPlan
+//
+// Pull requests are only acted upon when their state changes from the previous run, i.e. they become conflicting,
+// remain conflicting after new commits are pushed, or their conflicts are resolved. Without settings.state.path, no
+// state is remembered between runs, and every conflicting pull request is acted upon.
+//
+// Every issue linked to a pull request is acted upon, but only once per run, even when several pull requests share it.
+// Issues are only resolved when none of their pull requests remain conflicting.
 func (e *DefaultExecutionPlan) Execute() error {
+	e.report = newReport()
+
+	// providers that talk to Github share the plan's client, rather than each creating their own
+	if services.q == nil {
+		services.q = e.GithubClient
+	}
+
 	pulls, err := ListPulls(e.GithubClient)
 	if err != nil {
 		log.Println("Unable to fetch pull requests for repository: ", err)
 		return err
 	}
 
-	var issueID string
-	var ok bool
+	FetchTeamMembers(e.GithubClient)
 
+	store := services.state()
+
+	var selected []GithubPullRequest
 	for _, pull := range pulls {
+		if e.PullNumber != 0 && int(pull.Number) != e.PullNumber {
+			if previous, known := store.Get(int(pull.Number)); known {
+				store.Put(previous)
+			}
+
+			continue
+		}
+
+		selected = append(selected, pull)
+	}
+
+	results := checkConflicts(selected)
+
+	var checks []*pullCheck
+	conflictingIssues := map[string]bool{}
+
+	for i, pull := range selected {
 
 		log.Println("checking pull request:", pull.Number)
 
-		if issueID, ok = IssueID(pull); !ok {
-			log.Printf("no issue ID associated with this pull request '%d', skipping", pull.Number)
-			continue
+		previous, known := store.Get(int(pull.Number))
+
+		c := check(pull, results[i], previous, known)
+		e.report.Pulls = append(e.report.Pulls, c.report)
+
+		if c.state.Conflicting {
+			for _, id := range c.issueIDs {
+				conflictingIssues[id] = true
+			}
 		}
 
-		if hasConflict(pull) {
+		checks = append(checks, c)
+	}
 
-			log.Printf("pull request has conflict: %s", pull.URL)
+	acted := map[string]bool{}
+	var notified []*pullCheck
+	for _, c := range checks {
 
-			i := issue{ID: issueID, Owner: string(pull.Author.Login)}
+		switch c.action {
+		case actionNotify:
+			// the state of notified pull requests is saved once notifications are sent
+			notify(c, acted)
+			notified = append(notified, c)
+			continue
+		case actionResolve:
+			if resolve(c, conflictingIssues, acted) {
+				c.state.NotifiedAt = time.Now()
+			}
+		}
+
+		if !c.forget {
+			store.Put(c.state)
+		}
+	}
 
-			services.issues().TransitionIssue(i)
-			services.issues().CommentIssue(i)
+	sendNotifications(notified)
+
+	for _, c := range notified {
+		if c.report.acted() {
+			c.state.NotifiedAt = time.Now()
 		} else {
-			log.Printf("pull request is not conflicitng: %s", pull.URL)
+			// the conflict is reported again on the next run, rather than being remembered as reported
+			log.Printf("unable to report the conflict of pull request '%d', it will be reported again on the next run", c.pull.Number)
+			c.state, c.forget = c.previous, !c.known
+		}
+
+		if !c.forget {
+			store.Put(c.state)
 		}
 	}
 
-	return nil
+	e.report.FinishedAt = time.Now()
+	if err = writeReport(e.report); err != nil {
+		log.Println("Unable to write run report: ", err)
+	}
+
+	if err = writeOutputs(e.report); err != nil {
+		log.Println("Unable to write Github Actions outputs: ", err)
+	}
+
+	if config.SettingEnabled(config.DryRun) {
+		log.Println("Dry run: pull request state was not saved")
+		return nil
+	}
+
+	if err = store.Save(); err != nil {
+		log.Println("Unable to save pull request state: ", err)
+	}
+
+	return err
 }
 
-func (e DefaultExecutionPlan) client() GithubQueryer {
-	return e.GithubClient
+// check determines what action to take upon a pull request, based on the result of checking it for conflicts and its
+// previous state
+func check(pull GithubPullRequest, result conflictCheck, previous pullState, known bool) (c *pullCheck) {
+
+	c = &pullCheck{
+		pull:     pull,
+		issueIDs: IssueIDs(pull),
+		state: pullState{
+			Number:     int(pull.Number),
+			HeadSHA:    string(pull.HeadRefOid),
+			NotifiedAt: previous.NotifiedAt,
+		},
+		report:   newPullReport(pull),
+		previous: previous,
+		known:    known,
+	}
+
+	if len(c.issueIDs) > 0 {
+		c.report.IssueIDs = c.issueIDs
+	}
+
+	c.report.LocalMerge = result.localMerge
+	c.report.State = string(result.state)
+	c.report.Conflicting = result.state == conflictStateConflicting
+	c.files = result.files
+
+	if len(result.files) > 0 {
+		c.report.ConflictingFiles = result.files
+	}
+
+	switch result.state {
+	case conflictStateUnknown:
+		// an unknown state is no evidence that the pull request's state changed, so it is left unresolved
+		reason := "mergeable state is unknown"
+		if result.err != nil {
+			c.report.Error = result.err.Error()
+			reason = fmt.Sprintf("unable to merge locally: %v", result.err)
+		}
+
+		log.Printf("pull request state is unknown: %s", pull.URL)
+		c.report.skipped(actionCommentPull, pullTarget(pull), reason)
+		c.state = previous
+		c.forget = !known
+
+		return
+	case conflictStateClean:
+		log.Printf("pull request is not conflicitng: %s", pull.URL)
+
+		// without a previous state, prwatch relies on issue and pull request providers to tell whether the pull
+		// request was previously reported as conflicting
+		if !known || previous.Conflicting {
+			c.action = actionResolve
+		}
+
+		return
+	}
+
+	log.Printf("pull request has conflict: %s", pull.URL)
+
+	c.state.Conflicting = true
+
+	if known && previous.Conflicting && previous.HeadSHA == c.state.HeadSHA {
+		reportedAt := previous.NotifiedAt.Format(time.RFC3339)
+		log.Printf("pull request conflict was already reported at %s, skipping", reportedAt)
+		c.report.skipped(actionCommentPull, pullTarget(pull), fmt.Sprintf("conflict was already reported at %s", reportedAt))
+		return
+	}
+
+	c.action = actionNotify
+
+	return
+}
+
+// notify reports a pull request's conflict on the pull request and each of its issues. Issues in acted have already
+// been acted upon, and are skipped.
+func notify(c *pullCheck, acted map[string]bool) {
+
+	author := string(c.pull.Author.Login)
+	r := c.report
+
+	// pull request comments do not depend on an issue tracker
+	if config.UserSettingEnabled(author, config.PullComments) {
+		r.record(actionCommentPull, pullTarget(c.pull), services.pulls().CommentPull(c.pull, c.files), "unable to comment on pull request")
+	} else {
+		r.skipped(actionCommentPull, pullTarget(c.pull), disabledReason(author, config.PullComments))
+	}
+
+	if config.GetString(config.IssueProvider) == config.IssueProviderNone {
+		r.skipped(actionTransitionIssue, "", fmt.Sprintf("no issue tracker is used, '%s' is '%s'", config.IssueProvider, config.IssueProviderNone))
+		return
+	}
+
+	if len(c.issueIDs) == 0 {
+		log.Printf("no issue ID associated with this pull request '%d', skipping", c.pull.Number)
+		r.skipped(actionTransitionIssue, "", "no issue is associated with the pull request")
+		return
+	}
+
+	for _, id := range c.issueIDs {
+		if acted[id] {
+			log.Printf("issue '%s' was already acted upon, skipping", id)
+			r.skipped(actionTransitionIssue, id, "issue was already acted upon for another pull request")
+			continue
+		}
+
+		acted[id] = true
+
+		i := issue{ID: id, Owner: author, Files: c.files, Pull: c.pull}
+
+		if config.UserSettingEnabled(author, config.IssueTransitions) {
+			r.record(actionTransitionIssue, id, services.issues().TransitionIssue(i), "issue was not transitioned")
+		} else {
+			r.skipped(actionTransitionIssue, id, disabledReason(author, config.IssueTransitions))
+		}
+
+		if config.UserSettingEnabled(author, config.IssueComments) {
+			r.record(actionCommentIssue, id, services.issues().CommentIssue(i), "issue was not commented on")
+		} else {
+			r.skipped(actionCommentIssue, id, disabledReason(author, config.IssueComments))
+		}
+	}
 }
 
-// DualPassTimer returns a timer if DUAL_PASS_WAIT_DURATION contains a valid duration string, nil otherwise
-func (e DefaultExecutionPlan) DualPassTimer() (timer *time.Timer) {
+// resolve reports the resolution of conflicts on pull requests that were previously reported as conflicting. Issues
+// that are still linked to conflicting pull requests are not resolved.
+func resolve(c *pullCheck, conflictingIssues, acted map[string]bool) (resolved bool) {
+
+	r := c.report
+
+	resolved = services.pulls().ResolvePull(c.pull)
+	r.record(actionResolvePull, pullTarget(c.pull), resolved, "pull request was not previously reported as conflicting")
+
+	for _, id := range c.issueIDs {
+		if conflictingIssues[id] {
+			r.skipped(actionResolveIssue, id, "issue is associated with another conflicting pull request")
+			continue
+		}
 
-	if d := dualPassInterval(); d > 0 {
-		timer = time.NewTimer(d)
+		if acted[id] {
+			r.skipped(actionResolveIssue, id, "issue was already acted upon for another pull request")
+			continue
+		}
+
+		acted[id] = true
+
+		i := issue{ID: id, Owner: string(c.pull.Author.Login), Pull: c.pull, Reported: c.known && c.previous.Conflicting}
+		ok := services.issues().ResolveIssue(i)
+		r.record(actionResolveIssue, id, ok, "issue was not previously in conflict")
+
+		if ok {
+			resolved = true
+		}
 	}
 
 	return
 }
 
+// Report returns the report of the plan's most recent execution
+func (e *DefaultExecutionPlan) Report() *Report {
+	return e.report
+}
+
+func pullTarget(pr GithubPullRequest) string {
+	return fmt.Sprintf("#%d", pr.Number)
+}
+
+func disabledReason(user, setting string) string {
+	return fmt.Sprintf("'%s' is disabled for '%s' by %s", setting, user, config.Resolve(user, setting).Source())
+}
+
+func (e DefaultExecutionPlan) client() GithubQueryer {
+	return e.GithubClient
+}
+
 func dualPassInterval() time.Duration {
 
 	d := config.GetDuration(config.DualPassWaitDuration)
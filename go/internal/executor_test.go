@@ -1,10 +1,16 @@
 package internal
 
 import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/acaloiaro/prwatch/internal/config"
+	"github.com/shurcooL/githubv4"
 )
 
 type testExecutionPlan struct {
@@ -12,7 +18,6 @@ type testExecutionPlan struct {
 	firstPassFinished  time.Time
 	secondPassFinished time.Time
 	githubClient       GithubQueryer
-	t                  *time.Timer
 	f                  func() error
 }
 
@@ -23,10 +28,6 @@ func (t *testExecutionPlan) Execute() (err error) {
 	return
 }
 
-func (t testExecutionPlan) DualPassTimer() *time.Timer {
-	return t.t
-}
-
 func (t testExecutionPlan) client() GithubQueryer {
 	return t.githubClient
 }
@@ -42,7 +43,6 @@ func TestExecutorExecute(t *testing.T) {
 	st := &testExecutionPlan{
 		passCount:    0,
 		githubClient: client,
-		t:            time.NewTimer(dualPassInterval()), // DualPassTimer
 	}
 
 	// The second pass occurrs when the execution strategy's Execute() is called; f implements the strategy's Execute()
@@ -76,3 +76,264 @@ func TestExecutorExecute(t *testing.T) {
 		t.Error("phase 1 should have finished before phase 2")
 	}
 }
+
+func TestWaitForMergeability(t *testing.T) {
+
+	config.SetEnv("GITHUB_REPOSITORY", "acaloiaro/isok")
+	config.GlobalSet(config.DualPassPollInterval, "1ms")
+	config.GlobalSet(config.DualPassWaitDuration, "1s")
+
+	queried := map[int]int{}
+	client := &MockGithubClient{f: func(query interface{}, v map[string]interface{}) error {
+		q := query.(*pullRequestMergeableQuery)
+
+		number := int(v["number"].(githubv4.Int))
+		queried[number]++
+
+		// pull request 1's state becomes known on the second poll
+		q.Repository.PullRequest.Mergeable = githubv4.MergeableStateUnknown
+		if queried[number] == 2 {
+			q.Repository.PullRequest.Mergeable = githubv4.MergeableStateConflicting
+		}
+
+		return nil
+	}}
+
+	start := time.Now()
+	waitForMergeability(client, []GithubPullRequest{
+		{Number: 1, Mergeable: githubv4.MergeableStateUnknown},
+		{Number: 2, Mergeable: githubv4.MergeableStateMergeable},
+	})
+
+	if queried[1] != 2 || queried[2] != 0 {
+		t.Errorf("only pull requests with unknown state should be polled, until their state is known: %v", queried)
+	}
+
+	if time.Since(start) >= time.Second {
+		t.Error("polling should stop as soon as every pull request's state is known")
+	}
+
+	// polling gives up once the maximum wait has elapsed
+	config.GlobalSet(config.DualPassWaitDuration, "20ms")
+	queried = map[int]int{}
+	client.f = func(query interface{}, v map[string]interface{}) error {
+		q := query.(*pullRequestMergeableQuery)
+		q.Repository.PullRequest.Mergeable = githubv4.MergeableStateUnknown
+		queried[int(v["number"].(githubv4.Int))]++
+		return nil
+	}
+
+	start = time.Now()
+	waitForMergeability(client, []GithubPullRequest{{Number: 1, Mergeable: githubv4.MergeableStateUnknown}})
+
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond || elapsed > time.Second {
+		t.Errorf("polling should stop after the maximum wait, took: %s", elapsed)
+	}
+
+	if queried[1] < 2 {
+		t.Errorf("pull request should have been polled several times with backoff: %v", queried)
+	}
+}
+
+func mockPullsClient(pulls ...GithubPullRequest) *MockGithubClient {
+	return &MockGithubClient{f: func(query interface{}, v map[string]interface{}) error {
+		q := query.(*pullRequestQuery)
+		q.Repository.PullRequests = pullRequests{Nodes: pulls}
+
+		return nil
+	}}
+}
+
+func TestDefaultExecutionPlanStateTransitions(t *testing.T) {
+
+	defer services.reset()
+
+	dir, err := ioutil.TempDir("", "prwatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config.SetEnv("GITHUB_REPOSITORY", "acaloiaro/isok")
+	config.GlobalSet(config.JiraProjectName, "FOO")
+	config.GlobalSet(config.StatePath, filepath.Join(dir, "state.json"))
+	defer config.GlobalSet(config.StatePath, "")
+	config.GlobalEnable(config.PullComments)
+	defer config.GlobalDisable(config.PullComments)
+
+	issues := &mockIssueProvider{}
+	pulls := &mockPullCommenter{}
+	services.i = issues
+	services.c = pulls
+	services.f = mockFilesProvider{files: map[string]bool{".gitattributes": false}}
+
+	pr := GithubPullRequest{
+		Number:     1,
+		BodyText:   "FOO-1",
+		HeadRefOid: "abc123",
+		Mergeable:  githubv4.MergeableStateConflicting,
+	}
+
+	run := func(pr GithubPullRequest) {
+		plan := &DefaultExecutionPlan{GithubClient: mockPullsClient(pr)}
+		if err := plan.Execute(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// conflicts are not remembered as reported when every action fails, so they are reported again
+	issues.fail, pulls.fail = true, true
+	run(pr)
+	issues.fail, pulls.fail = false, false
+	if len(pulls.commented) != 1 || len(issues.transitioned) != 1 {
+		t.Errorf("conflicting pull request should have been acted upon")
+	}
+
+	pulls.commented, issues.transitioned, issues.commented = nil, nil, nil
+
+	// a newly conflicting pull request is acted upon
+	run(pr)
+	if len(pulls.commented) != 1 || len(issues.transitioned) != 1 || len(issues.commented) != 1 {
+		t.Errorf("newly conflicting pull request, and those that could not be reported, should have been acted upon")
+	}
+
+	// a pull request that remains conflicting is not acted upon again
+	run(pr)
+	if len(pulls.commented) != 1 || len(issues.transitioned) != 1 {
+		t.Errorf("previously reported conflicts should not be acted upon again")
+	}
+
+	// new commits that do not resolve the conflict are acted upon
+	pr.HeadRefOid = "def456"
+	run(pr)
+	if len(pulls.commented) != 2 || len(issues.transitioned) != 2 {
+		t.Errorf("pull requests that remain conflicting after new commits should be acted upon")
+	}
+
+	// resolved conflicts are acted upon once
+	pr.Mergeable = githubv4.MergeableStateMergeable
+	run(pr)
+	run(pr)
+	if len(pulls.resolved) != 1 || len(issues.resolved) != 1 {
+		t.Errorf("resolved conflicts should have been acted upon once: %d", len(pulls.resolved))
+	}
+}
+
+func TestDefaultExecutionPlanPullNumber(t *testing.T) {
+
+	defer services.reset()
+
+	config.SetEnv("GITHUB_REPOSITORY", "acaloiaro/isok")
+	config.GlobalEnable(config.PullComments)
+	defer config.GlobalDisable(config.PullComments)
+
+	pulls := &mockPullCommenter{}
+	services.i = &mockIssueProvider{}
+	services.c = pulls
+	services.f = mockFilesProvider{files: map[string]bool{".gitattributes": false}}
+
+	plan := &DefaultExecutionPlan{
+		GithubClient: mockPullsClient(
+			GithubPullRequest{Number: 1, Mergeable: githubv4.MergeableStateConflicting},
+			GithubPullRequest{Number: 2, Mergeable: githubv4.MergeableStateConflicting},
+		),
+		PullNumber: 2,
+	}
+
+	if err := plan.Execute(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pulls.commented) != 1 || pulls.commented[0].Number != 2 {
+		t.Errorf("only pull request 2 should have been checked: %v", pulls.commented)
+	}
+}
+
+func TestDefaultExecutionPlanLinkedIssues(t *testing.T) {
+
+	defer services.reset()
+
+	config.SetEnv("GITHUB_REPOSITORY", "acaloiaro/isok")
+	config.GlobalSet(config.JiraProjectName, "FOO")
+	config.GlobalSet(config.IssueResolvedStatus, "In Review")
+	defer config.GlobalSet(config.IssueResolvedStatus, "")
+
+	issues := &mockIssueProvider{}
+	services.i = issues
+	services.c = &mockPullCommenter{}
+	services.f = mockFilesProvider{files: map[string]bool{".gitattributes": false}}
+
+	plan := &DefaultExecutionPlan{
+		GithubClient: mockPullsClient(
+			GithubPullRequest{Number: 1, BodyText: "Closes FOO-1 and FOO-2", Mergeable: githubv4.MergeableStateConflicting},
+			GithubPullRequest{Number: 2, BodyText: "Closes FOO-1", Mergeable: githubv4.MergeableStateConflicting},
+			GithubPullRequest{Number: 3, BodyText: "Closes FOO-2 and FOO-3", Mergeable: githubv4.MergeableStateMergeable},
+		),
+	}
+
+	if err := plan.Execute(); err != nil {
+		t.Fatal(err)
+	}
+
+	var transitioned []string
+	for _, i := range issues.transitioned {
+		transitioned = append(transitioned, i.ID)
+	}
+
+	if strings.Join(transitioned, ",") != "FOO-1,FOO-2" {
+		t.Errorf("every linked issue should be transitioned exactly once, got: %v", transitioned)
+	}
+
+	// FOO-2 is still linked to a conflicting pull request
+	if len(issues.resolved) != 1 || issues.resolved[0].ID != "FOO-3" {
+		t.Errorf("only issues without conflicting pull requests should be resolved, got: %v", issues.resolved)
+	}
+
+	report := plan.Report()
+	if len(report.Pulls) != 3 {
+		t.Fatalf("every checked pull request should be reported, got: %d", len(report.Pulls))
+	}
+
+	var skipped *ActionReport
+	for _, a := range report.Pulls[1].Actions {
+		if a.Action == actionTransitionIssue && a.Target == "FOO-1" {
+			skipped = a
+		}
+	}
+
+	if skipped == nil || skipped.Taken || skipped.Reason == "" {
+		t.Errorf("transitioning an issue twice should be reported as skipped, with a reason: %+v", skipped)
+	}
+}
+
+func TestDefaultExecutionPlanMergeError(t *testing.T) {
+
+	defer services.reset()
+
+	config.SetEnv("GITHUB_REPOSITORY", "acaloiaro/isok")
+	config.GlobalEnable(config.PullComments)
+	defer config.GlobalDisable(config.PullComments)
+
+	pulls := &mockPullCommenter{}
+	services.i = &mockIssueProvider{}
+	services.c = pulls
+	services.f = mockFilesProvider{files: map[string]bool{".gitattributes": true}}
+	services.g = &mockGitProvider{fetchFunc: func(depth int, refspecs ...string) error { return errors.New("fail") }}
+
+	plan := &DefaultExecutionPlan{
+		GithubClient: mockPullsClient(GithubPullRequest{Number: 1, Mergeable: githubv4.MergeableStateConflicting}),
+	}
+
+	if err := plan.Execute(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pulls.commented) != 0 || len(pulls.resolved) != 0 {
+		t.Error("pull requests that could not be merged locally should not be acted upon")
+	}
+
+	r := plan.Report().Pulls[0]
+	if r.Conflicting || r.Error == "" {
+		t.Errorf("the failed local merge should have been reported: %+v", r)
+	}
+}
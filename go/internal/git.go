@@ -1,90 +1,304 @@
 package internal
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
+
+	"github.com/acaloiaro/prwatch/internal/config"
 )
 
+// defaultGitConcurrency is the number of local merges performed at once when settings.git.concurrency is not set
+const defaultGitConcurrency = 4
+
+// errMergeConflict is returned by gitProvider.Merge and gitProvider.MergeTree when refs cannot be merged without
+// conflicts
+var errMergeConflict = errors.New("merge conflict")
+
+// conflictMarker begins each conflicting hunk of a file left with conflict markers by a merge
+var conflictMarker = regexp.MustCompile(`(?m)^<<<<<<< `)
+
+// ConflictFile is a file that could not be merged, and the number of conflicting hunks in it. Files that conflict
+// without conflict markers, e.g. binary files and files deleted on one side, have no hunks.
+type ConflictFile struct {
+	Path  string `json:"path"`
+	Hunks int    `json:"hunks"`
+}
+
+// worktreeLock serializes adding and removing worktrees, since git reads the administrative files of every worktree
+// when doing either, and fails on those that are only partly written
+var worktreeLock sync.Mutex
+
+// objectID matches the git object ID that output from `git merge-tree --write-tree` begins with
+var objectID = regexp.MustCompile(`^[0-9a-f]{40}([0-9a-f]{24})?\n`)
+
 // tryMerge attempts to merge a pull request locally
 // The purpose of merging locally is that Github's  Mergable status s insufficient when a .gitatributes file
 // is present. Because Github does not support custom merge drivers, e.g. `merge=union` from .gitattributes, merging
 // using a git client that does support custom merge drivers is the only way to tell whether a branch is truly mergable.
-func tryMerge(pr GithubPullRequest) (success bool) {
+//
+// The pull request's head and base branch must already have been fetched, see fetchPulls.
+//
+// Merges are performed in a temporary worktree of the base ref, leaving the current checkout untouched, so that
+// several pull requests may be merged at once.
+//
+// With settings.git.merge_mode set to 'merge-tree', refs are merged with `git merge-tree` instead, which neither needs a
+// worktree nor touches the working tree or HEAD.
+//
+// When the merge conflicts, the conflicting files are returned. An error is returned when the pull request could not be
+// merged at all, in which case whether it conflicts is unknown.
+func tryMerge(pr GithubPullRequest) (success bool, files []ConflictFile, err error) {
 
 	g := services.git()
 
-	baseRef := fmt.Sprintf("origin/%s", string(pr.BaseRefName))
-	mergeRef := fmt.Sprintf("origin/%s", string(pr.HeadRefName))
+	baseRef := pullBaseRef(pr)
+	mergeRef := pullMergeRef(pr)
+
+	if config.GetString(config.GitMergeMode) == config.GitMergeModeMergeTree {
+		log.Printf("trying to merge '%s' into '%s' with merge-tree", mergeRef, baseRef)
+
+		files, err = g.MergeTree(baseRef, mergeRef)
+
+		return mergeResult(files, err)
+	}
+
+	dir, err := ioutil.TempDir("", "prwatch-merge-")
+	if err != nil {
+		err = fmt.Errorf("unable to create worktree directory: %v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
 
-	err := g.Checkout(baseRef)
+	err = g.AddWorktree(dir, baseRef)
 	if err != nil {
-		log.Printf("Error checking out base ref: %v", err)
+		err = fmt.Errorf("unable to check out base ref: %v", err)
 		return
 	}
 
-	origBranchRef := g.CurrentRefName()
+	defer func() {
+		if err := g.RemoveWorktree(dir); err != nil {
+			log.Printf("unable to remove worktree: %v", err)
+		}
+	}()
 
 	log.Printf("trying to merge '%s' into '%s'", mergeRef, baseRef)
 
-	err = g.Merge(mergeRef)
-	if err != nil {
-		log.Printf("Error trying to merge: %v", err)
+	return mergeResult(g.In(dir).Merge(mergeRef))
+}
+
+// fetchPulls fetches the head, refs/pull/<number>/head, and base branch of each pull request from origin, to a depth of
+// settings.git.fetch_depth, so that pull requests from forks and shallow clones can be merged.
+//
+// All refs are fetched at once. When that fails, e.g. because a pull request's head no longer exists, pull requests are
+// fetched one at a time instead, so that only those that cannot be fetched go unmerged. The errors of pull requests
+// that could not be fetched are returned by pull request number.
+func fetchPulls(pulls []GithubPullRequest) (errs map[int]error) {
+
+	errs = map[int]error{}
+	if len(pulls) == 0 {
 		return
 	}
 
-	//reset HEAD back to the HEAD prior to merging
-	err = g.Reset(origBranchRef, "--hard")
-	if err != nil {
-		log.Printf("unable to reset head: %v", err)
+	g := services.git()
+	depth := config.GetInt(config.GitFetchDepth)
+
+	var refspecs []string
+	bases := map[string]bool{}
+	for _, pr := range pulls {
+		base, head := pullRefspecs(pr)
+		if !bases[base] {
+			bases[base] = true
+			refspecs = append(refspecs, base)
+		}
+
+		refspecs = append(refspecs, head)
+	}
+
+	err := g.Fetch(depth, refspecs...)
+	if err == nil {
 		return
 	}
 
-	success = true
+	log.Printf("unable to fetch pull requests at once, fetching them one at a time: %v", err)
+
+	for _, pr := range pulls {
+		base, head := pullRefspecs(pr)
+		if err = g.Fetch(depth, base, head); err != nil {
+			errs[int(pr.Number)] = fmt.Errorf("unable to fetch pull request: %v", err)
+		}
+	}
+
+	return
+}
+
+// pullBaseRef is the remote-tracking ref that a pull request's base branch is fetched to
+func pullBaseRef(pr GithubPullRequest) string {
+	return fmt.Sprintf("origin/%s", string(pr.BaseRefName))
+}
+
+// pullMergeRef is the ref that a pull request's head is fetched to
+func pullMergeRef(pr GithubPullRequest) string {
+	return fmt.Sprintf("refs/prwatch/pull/%d", pr.Number)
+}
+
+// pullRefspecs returns the refspecs that fetch a pull request's base branch and head
+func pullRefspecs(pr GithubPullRequest) (base, head string) {
+	base = fmt.Sprintf("+refs/heads/%s:refs/remotes/%s", string(pr.BaseRefName), pullBaseRef(pr))
+	head = fmt.Sprintf("+refs/pull/%d/head:%s", pr.Number, pullMergeRef(pr))
+	return
+}
+
+// mergeResult interprets the result of a local merge, telling conflicts apart from failures to merge at all
+func mergeResult(files []ConflictFile, err error) (success bool, conflicts []ConflictFile, mergeErr error) {
+
+	switch err {
+	case nil:
+		success = true
+	case errMergeConflict:
+		log.Printf("merge has conflicts: %v", files)
+		conflicts = files
+	default:
+		mergeErr = fmt.Errorf("unable to merge: %v", err)
+	}
 
 	return
 }
 
+// checkConflicts checks pull requests for conflicts using up to settings.git.concurrency workers. Results are returned
+// in the same order as pulls.
+//
+// Pull requests that are merged locally are all fetched before any is merged, so that workers only merge.
+func checkConflicts(pulls []GithubPullRequest) []conflictCheck {
+
+	results := make([]conflictCheck, len(pulls))
+
+	var merging []GithubPullRequest
+	for _, pr := range pulls {
+		if mergesLocally(pr) {
+			merging = append(merging, pr)
+		}
+	}
+
+	unfetched := fetchPulls(merging)
+
+	concurrency := config.GetInt(config.GitConcurrency)
+	if concurrency < 1 {
+		concurrency = defaultGitConcurrency
+	}
+
+	// providers are created lazily, so create those the workers use before the workers share them
+	services.git()
+	services.files()
+
+	work := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				pr := pulls[i]
+				if err, ok := unfetched[int(pr.Number)]; ok {
+					log.Printf("unable to merge pull request '%d' locally: %v", pr.Number, err)
+					results[i] = conflictCheck{state: conflictStateUnknown, localMerge: true, err: err}
+					continue
+				}
+
+				results[i] = checkConflict(pr)
+			}
+		}()
+	}
+
+	for i := range pulls {
+		work <- i
+	}
+
+	close(work)
+	wg.Wait()
+
+	return results
+}
+
 // gitProvider is an interface for performing various Git operations
 type gitProvider interface {
-	Checkout(ref string) error
-	CurrentRefName() string
-	Merge(ref string, args ...string) error
-	Reset(ref string, args ...string) error
+	AddWorktree(path, ref string) error
+	Fetch(depth int, refspecs ...string) error
+	In(dir string) gitProvider
+	Merge(ref string, args ...string) ([]ConflictFile, error)
+	MergeTree(base, head string) ([]ConflictFile, error)
+	RemoveWorktree(path string) error
 }
 
 // GitCommandLine is a gitProvider for the command-line executable of git, i.e. "git" proper
 // Until there is a 100% Golang git implementation that supports .gitattributes files, this is the preferred method of
 // executing git operations on the local git repository.
-type GitCommandLine struct{}
+type GitCommandLine struct {
+	// Dir is the directory git commands are run in. The current directory is used when it is empty.
+	Dir string
+}
+
+// In returns a GitCommandLine that runs git commands in dir, e.g. a worktree
+func (gcl *GitCommandLine) In(dir string) gitProvider {
+	return &GitCommandLine{Dir: dir}
+}
+
+// AddWorktree checks out git reference `ref` as a detached worktree at `path`
+func (gcl *GitCommandLine) AddWorktree(path, ref string) error {
+
+	worktreeLock.Lock()
+	out, err := gcl.command("worktree", "add", "--detach", path, ref).CombinedOutput()
+	worktreeLock.Unlock()
+
+	if err != nil {
+		log.Println("error adding worktree:", string(out))
+	}
+
+	return err
+}
+
+// Fetch fetches `refspecs` from origin. When `depth` is positive, history is fetched to that depth.
+func (gcl *GitCommandLine) Fetch(depth int, refspecs ...string) error {
 
-// CurrentRefName returns the ref of the current HEAD
-func (gcl *GitCommandLine) CurrentRefName() string {
+	args := []string{"fetch", "--no-tags", "--no-write-fetch-head"}
+	if depth > 0 {
+		args = append(args, fmt.Sprintf("--depth=%d", depth))
+	}
 
-	// this Github action uses `actions/checkout`, which places the repo in a "detached head" state,
-	// so this command gives us the sha of the detatched head
-	origBranchRef, _ := exec.Command("git", "rev-parse", "HEAD").Output()
+	out, err := gcl.command(append(append(args, "origin"), refspecs...)...).CombinedOutput()
+	if err != nil {
+		log.Println("error fetching:", string(out))
+	}
 
-	return strings.TrimSpace(string(origBranchRef))
+	return err
 }
 
-// Checkout checks out git reference `ref`
-func (gcl *GitCommandLine) Checkout(ref string) error {
+// RemoveWorktree removes the worktree at `path`, discarding any changes made in it
+func (gcl *GitCommandLine) RemoveWorktree(path string) error {
+
+	worktreeLock.Lock()
+	out, err := gcl.command("worktree", "remove", "--force", path).CombinedOutput()
+	worktreeLock.Unlock()
 
-	out, err := exec.Command("git", "checkout", ref).CombinedOutput()
 	if err != nil {
-		log.Println("error checking out branch:", string(out))
+		log.Println("error removing worktree:", string(out))
 	}
 
 	return err
 }
 
-// Merge merges git reference `ref` with the current HEAD, passing `args` to the merge command
-func (gcl *GitCommandLine) Merge(ref string, args ...string) error {
+// Merge merges git reference `ref` with the current HEAD, passing `args` to the merge command. When the merge conflicts,
+// errMergeConflict is returned with the conflicting files.
+func (gcl *GitCommandLine) Merge(ref string, args ...string) (files []ConflictFile, err error) {
 
-	out, err := exec.Command(
-		"git",
+	combinedArgs := append([]string{
 		"-c",
 		"user.name=prwatch",
 		"-c",
@@ -92,24 +306,106 @@ func (gcl *GitCommandLine) Merge(ref string, args ...string) error {
 		"merge",
 		ref,
 		"-m",
-		"Test merge").CombinedOutput()
+		"Test merge"}, args...)
 
-	if err != nil {
-		log.Println("Error merging branch:", string(out))
+	out, err := gcl.command(combinedArgs...).CombinedOutput()
+	if err == nil {
+		return
 	}
 
-	return err
+	log.Println("Error merging branch:", string(out))
+
+	out, diffErr := gcl.command("diff", "--name-only", "--diff-filter=U").Output()
+	if diffErr != nil {
+		log.Printf("unable to list conflicting files: %v", diffErr)
+		return
+	}
+
+	// a merge that failed without leaving unmerged files failed for reasons other than conflicts
+	paths := lines(out)
+	if len(paths) == 0 {
+		return
+	}
+
+	for _, path := range paths {
+		content, readErr := ioutil.ReadFile(filepath.Join(gcl.Dir, path))
+		if readErr != nil {
+			log.Printf("unable to read conflicting file '%s': %v", path, readErr)
+		}
+
+		files = append(files, ConflictFile{Path: path, Hunks: countHunks(content)})
+	}
+
+	return files, errMergeConflict
 }
 
-// Reset resets HEAD to git reference `ref`, passing `args` to the reset command
-func (gcl *GitCommandLine) Reset(ref string, args ...string) error {
+// MergeTree merges git reference `head` into `base` without touching the working tree or HEAD, returning
+// errMergeConflict and the conflicting files when they conflict. Merge drivers are taken from the working tree's
+// .gitattributes.
+func (gcl *GitCommandLine) MergeTree(base, head string) (files []ConflictFile, err error) {
 
-	combinedArgs := append([]string{"reset", string(ref)}, args...)
+	out, err := gcl.command("merge-tree", "--write-tree", "--name-only", base, head).CombinedOutput()
+	if err == nil {
+		return
+	}
 
-	err := exec.Command("git", combinedArgs...).Run()
-	if err != nil {
-		log.Println("Error resetting branch:", err, combinedArgs)
+	// merge-tree exits with status 1 and prints the merged tree's object ID when the merge has conflicts. It also exits
+	// with status 1 when it could not merge at all, e.g. when a ref does not exist, but then prints no object ID.
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok || exitErr.ExitCode() != 1 || !objectID.Match(out) {
+		log.Println("Error running merge-tree:", string(out))
+		return
 	}
 
-	return err
+	log.Println("merge-tree conflicts:", string(out))
+
+	// the object ID is followed by the conflicting files, one per line, and then a blank line
+	output := strings.Split(string(out), "\n")
+	tree := output[0]
+	seen := map[string]bool{}
+
+	for _, path := range output[1:] {
+		if path == "" {
+			break
+		}
+
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		// the merged tree contains conflicting files with their conflict markers
+		content, catErr := gcl.command("cat-file", "-p", fmt.Sprintf("%s:%s", tree, path)).Output()
+		if catErr != nil {
+			log.Printf("unable to read conflicting file '%s': %v", path, catErr)
+		}
+
+		files = append(files, ConflictFile{Path: path, Hunks: countHunks(content)})
+	}
+
+	return files, errMergeConflict
+}
+
+func (gcl *GitCommandLine) command(args ...string) *exec.Cmd {
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = gcl.Dir
+
+	return cmd
+}
+
+// countHunks counts the conflicting hunks in the content of a file left with conflict markers
+func countHunks(content []byte) int {
+	return len(conflictMarker.FindAllIndex(content, -1))
+}
+
+func lines(out []byte) (l []string) {
+
+	for _, line := range strings.Split(string(out), "\n") {
+		if line != "" {
+			l = append(l, line)
+		}
+	}
+
+	return
 }
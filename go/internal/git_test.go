@@ -2,44 +2,67 @@ package internal
 
 import (
 	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/acaloiaro/prwatch/internal/config"
+	"github.com/shurcooL/githubv4"
 )
 
 type mockGitProvider struct {
-	checkoutCalled   time.Time
-	checkoutFunc     func(ref string) error
-	currentRefCalled time.Time
-	currentRefFunc   func() string
-	mergeCalled      time.Time
-	mergeFunc        func(ref string, args ...string) error
-	resetCalled      time.Time
-	resetFunc        func(ref string, args ...string) error
+	addWorktreeCalled    time.Time
+	addWorktreeFunc      func(path, ref string) error
+	fetchCalled          time.Time
+	fetchFunc            func(depth int, refspecs ...string) error
+	mergeCalled          time.Time
+	mergeDir             string
+	mergeFunc            func(ref string, args ...string) ([]ConflictFile, error)
+	mergeTreeCalled      time.Time
+	mergeTreeFunc        func(base, head string) ([]ConflictFile, error)
+	removeWorktreeCalled time.Time
+	removeWorktreeFunc   func(path string) error
+	worktree             string
 }
 
-func (e *mockGitProvider) CurrentRefName() string {
+func (e *mockGitProvider) AddWorktree(path, ref string) error {
 
-	e.currentRefCalled = time.Now()
+	e.addWorktreeCalled = time.Now()
+	e.worktree = path
 
-	if e.currentRefFunc != nil {
-		return e.currentRefFunc()
+	if e.addWorktreeFunc != nil {
+		return e.addWorktreeFunc(path, ref)
 	}
 
-	return "undefined"
+	return nil
 }
 
-func (e *mockGitProvider) Checkout(ref string) error {
+func (e *mockGitProvider) Fetch(depth int, refspecs ...string) error {
 
-	e.checkoutCalled = time.Now()
+	e.fetchCalled = time.Now()
 
-	if e.checkoutFunc != nil {
-		return e.checkoutFunc(ref)
+	if e.fetchFunc != nil {
+		return e.fetchFunc(depth, refspecs...)
 	}
 
 	return nil
 }
 
-func (e *mockGitProvider) Merge(ref string, args ...string) error {
+func (e *mockGitProvider) In(dir string) gitProvider {
+
+	e.mergeDir = dir
+
+	return e
+}
+
+func (e *mockGitProvider) Merge(ref string, args ...string) ([]ConflictFile, error) {
 
 	e.mergeCalled = time.Now()
 
@@ -47,15 +70,26 @@ func (e *mockGitProvider) Merge(ref string, args ...string) error {
 		return e.mergeFunc(ref, args...)
 	}
 
-	return nil
+	return nil, nil
+}
+
+func (e *mockGitProvider) MergeTree(base, head string) ([]ConflictFile, error) {
+
+	e.mergeTreeCalled = time.Now()
+
+	if e.mergeTreeFunc != nil {
+		return e.mergeTreeFunc(base, head)
+	}
+
+	return nil, nil
 }
 
-func (e *mockGitProvider) Reset(ref string, args ...string) error {
+func (e *mockGitProvider) RemoveWorktree(path string) error {
 
-	e.resetCalled = time.Now()
+	e.removeWorktreeCalled = time.Now()
 
-	if e.resetFunc != nil {
-		return e.resetFunc(ref, args...)
+	if e.removeWorktreeFunc != nil {
+		return e.removeWorktreeFunc(path)
 	}
 
 	return nil
@@ -67,36 +101,376 @@ func TestTryMerge(t *testing.T) {
 	defer services.reset()
 
 	pr := GithubPullRequest{
+		Number:      7,
 		BaseRefName: "foo",
 		HeadRefName: "bar",
 	}
 
-	services.g = &mockGitProvider{checkoutFunc: func(ref string) error { return errors.New("fail") }}
-	status := tryMerge(pr)
-	if status {
-		t.Error("Should not have been able to merge")
+	services.g = &mockGitProvider{addWorktreeFunc: func(path, ref string) error { return errors.New("fail") }}
+	if status, _, err := tryMerge(pr); status || err == nil {
+		t.Error("a failed checkout should have been reported as an error")
 	}
 
-	services.g = &mockGitProvider{mergeFunc: func(ref string, a ...string) error { return errors.New("fail") }}
-	status = tryMerge(pr)
-	if status {
-		t.Error("Should not have been able to merge")
+	services.g = &mockGitProvider{mergeFunc: func(ref string, a ...string) ([]ConflictFile, error) {
+		return nil, errors.New("fail")
+	}}
+	if status, _, err := tryMerge(pr); status || err == nil {
+		t.Error("a failed merge without conflicts should have been reported as an error")
 	}
 
-	services.g = &mockGitProvider{resetFunc: func(ref string, a ...string) error { return errors.New("fail") }}
-	status = tryMerge(pr)
-	if status {
-		t.Error("Should not have been able to merge")
+	conflicts := []ConflictFile{{Path: "foo.go", Hunks: 2}}
+	p := &mockGitProvider{mergeFunc: func(ref string, a ...string) ([]ConflictFile, error) {
+		if ref != "refs/prwatch/pull/7" {
+			t.Errorf("unexpected ref merged: '%s'", ref)
+		}
+
+		return conflicts, errMergeConflict
+	}}
+	services.g = p
+	status, files, err := tryMerge(pr)
+	if status || err != nil {
+		t.Errorf("Should not have been able to merge, without error: %v", err)
+	}
+
+	if !reflect.DeepEqual(files, conflicts) {
+		t.Errorf("expected conflicting files %v, got %v", conflicts, files)
 	}
 
-	p := &mockGitProvider{}
+	if p.removeWorktreeCalled.IsZero() {
+		t.Error("worktree should have been removed after a failed merge")
+	}
+
+	p = &mockGitProvider{}
 	services.g = p
-	status = tryMerge(pr)
-	if !status {
-		t.Error("Should have been able to merge")
+	if status, _, err = tryMerge(pr); !status || err != nil {
+		t.Errorf("Should have been able to merge: %v", err)
 	}
 
-	if p.currentRefCalled.Before(p.checkoutCalled) || p.mergeCalled.Before(p.checkoutCalled) || p.resetCalled.Before(p.mergeCalled) {
+	if p.mergeCalled.Before(p.addWorktreeCalled) || p.removeWorktreeCalled.Before(p.mergeCalled) {
 		t.Error("git operations called in the wrong order")
 	}
+
+	if p.worktree == "" || p.mergeDir != p.worktree {
+		t.Errorf("merge should have been performed in the worktree '%s', not '%s'", p.worktree, p.mergeDir)
+	}
+}
+
+func TestFetchPulls(t *testing.T) {
+
+	defer services.reset()
+	defer config.Reset()
+
+	config.GlobalSet(config.GitFetchDepth, "50")
+
+	var depth int
+	var fetches [][]string
+	services.g = &mockGitProvider{fetchFunc: func(d int, r ...string) error {
+		depth = d
+		fetches = append(fetches, r)
+		return nil
+	}}
+
+	pulls := []GithubPullRequest{
+		{Number: 7, BaseRefName: "foo", HeadRefName: "bar"},
+		{Number: 8, BaseRefName: "foo", HeadRefName: "baz"},
+	}
+
+	// pull requests are fetched at once, sharing the fetch of their base branch
+	if errs := fetchPulls(pulls); len(errs) > 0 {
+		t.Errorf("pull requests should have been fetched: %v", errs)
+	}
+
+	expected := [][]string{{"+refs/heads/foo:refs/remotes/origin/foo", "+refs/pull/7/head:refs/prwatch/pull/7", "+refs/pull/8/head:refs/prwatch/pull/8"}}
+	if depth != 50 || !reflect.DeepEqual(fetches, expected) {
+		t.Errorf("expected to fetch %v to depth 50, fetched %v to depth %d", expected, fetches, depth)
+	}
+
+	// when they cannot be fetched at once, they are fetched one at a time
+	fetches = nil
+	services.g = &mockGitProvider{fetchFunc: func(d int, r ...string) error {
+		fetches = append(fetches, r)
+		if len(r) > 2 || r[1] == "+refs/pull/8/head:refs/prwatch/pull/8" {
+			return errors.New("fail")
+		}
+
+		return nil
+	}}
+
+	errs := fetchPulls(pulls)
+	if len(fetches) != 3 || len(errs) != 1 || errs[8] == nil {
+		t.Errorf("only pull request 8 should have failed to be fetched, got %v after fetching %v", errs, fetches)
+	}
+}
+
+func TestTryMergeTree(t *testing.T) {
+
+	defer services.reset()
+	defer config.Reset()
+
+	config.GlobalSet(config.GitMergeMode, config.GitMergeModeMergeTree)
+
+	pr := GithubPullRequest{
+		Number:      7,
+		BaseRefName: "foo",
+		HeadRefName: "bar",
+	}
+
+	conflicts := []ConflictFile{{Path: "foo.go", Hunks: 1}}
+	p := &mockGitProvider{mergeTreeFunc: func(base, head string) ([]ConflictFile, error) {
+		if base != "origin/foo" || head != "refs/prwatch/pull/7" {
+			t.Errorf("unexpected refs merged: '%s' into '%s'", head, base)
+		}
+
+		return conflicts, errMergeConflict
+	}}
+	services.g = p
+
+	if ok, files, err := tryMerge(pr); ok || err != nil || !reflect.DeepEqual(files, conflicts) {
+		t.Errorf("Should not have been able to merge, and should have found conflicting files %v, got %v: %v", conflicts, files, err)
+	}
+
+	if !p.addWorktreeCalled.IsZero() || !p.mergeCalled.IsZero() {
+		t.Error("merge-tree mode should not use a worktree")
+	}
+
+	services.g = &mockGitProvider{mergeTreeFunc: func(base, head string) ([]ConflictFile, error) {
+		return nil, errors.New("fail")
+	}}
+	if ok, _, err := tryMerge(pr); ok || err == nil {
+		t.Error("a failed merge-tree should have been reported as an error")
+	}
+
+	services.g = &mockGitProvider{}
+	if ok, _, err := tryMerge(pr); !ok || err != nil {
+		t.Errorf("Should have been able to merge: %v", err)
+	}
+}
+
+// testRepository creates a git repository with a 'base' branch and branches that conflict with it: 'union', whose
+// conflicts are resolved by the union merge driver, and 'plain', which has two conflicting hunks in plain.txt. It is
+// skipped when git is not installed.
+func testRepository(t *testing.T) (dir string) {
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir, err := ioutil.TempDir("", "prwatch-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+
+	write := func(name, content string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	plain := "1\n2\n3\n4\n5\n6\n7\n8\n"
+
+	run("init", "-q")
+	write("union.txt", "base\n")
+	write("plain.txt", plain)
+	write(".gitattributes", "union.txt merge=union\n")
+	run("add", "-A")
+	run("commit", "-q", "-m", "base")
+	run("branch", "-M", "base")
+	run("checkout", "-q", "-b", "union")
+	write("union.txt", "base\nunion\n")
+	run("commit", "-q", "-am", "union")
+	run("checkout", "-q", "-b", "plain", "base")
+	write("plain.txt", strings.Replace(strings.Replace(plain, "1", "one", 1), "8", "eight", 1))
+	run("commit", "-q", "-am", "plain")
+	run("checkout", "-q", "base")
+	write("union.txt", "base\nmain\n")
+	write("plain.txt", strings.Replace(strings.Replace(plain, "1", "uno", 1), "8", "ocho", 1))
+	run("commit", "-q", "-am", "main")
+
+	return
+}
+
+func TestGitCommandLineMerge(t *testing.T) {
+
+	dir := testRepository(t)
+	defer os.RemoveAll(dir)
+
+	g := &GitCommandLine{Dir: dir}
+	conflicts := []ConflictFile{{Path: "plain.txt", Hunks: 2}}
+
+	if files, err := g.Merge("union"); err != nil || len(files) > 0 {
+		t.Errorf("union merge driver should have resolved the conflict: %v %v", err, files)
+	}
+
+	if files, err := g.Merge("plain"); err != errMergeConflict || !reflect.DeepEqual(files, conflicts) {
+		t.Errorf("expected conflicting files %v, got %v: %v", conflicts, files, err)
+	}
+}
+
+func TestGitCommandLineMergeTree(t *testing.T) {
+
+	dir := testRepository(t)
+	defer os.RemoveAll(dir)
+
+	g := &GitCommandLine{Dir: dir}
+	conflicts := []ConflictFile{{Path: "plain.txt", Hunks: 2}}
+
+	if files, err := g.MergeTree("base", "union"); err != nil || len(files) > 0 {
+		t.Errorf("union merge driver should have resolved the conflict: %v %v", err, files)
+	}
+
+	if files, err := g.MergeTree("base", "plain"); err != errMergeConflict || !reflect.DeepEqual(files, conflicts) {
+		t.Errorf("expected conflicting files %v, got %v: %v", conflicts, files, err)
+	}
+
+	if _, err := g.MergeTree("base", "nonexistent"); err == nil || err == errMergeConflict {
+		t.Errorf("expected an error merging a nonexistent ref, got: %v", err)
+	}
+
+	cmd := exec.Command("git", "status", "--porcelain")
+	cmd.Dir = dir
+	if out, _ := cmd.Output(); len(out) > 0 {
+		t.Errorf("merge-tree should not have changed the working tree: %s", out)
+	}
+}
+
+// TestTryMergeFork merges pull requests in a clone that has fetched neither their head nor their base branch, as is the
+// case for pull requests from forks
+func TestTryMergeFork(t *testing.T) {
+
+	defer services.reset()
+
+	origin := testRepository(t)
+	defer os.RemoveAll(origin)
+
+	clone, err := ioutil.TempDir("", "prwatch-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(clone)
+
+	git := func(dir string, args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+
+	// pull request heads only exist as refs/pull/<number>/head on the origin
+	git(origin, "update-ref", "refs/pull/1/head", "union")
+	git(origin, "update-ref", "refs/pull/2/head", "plain")
+	git(origin, "branch", "-D", "union", "plain")
+	git(clone, "clone", "-q", "--single-branch", "--branch", "base", origin, ".")
+	git(clone, "branch", "-q", "-r", "-d", "origin/base")
+
+	services.g = &GitCommandLine{Dir: clone}
+	services.f = mockFilesProvider{files: map[string]bool{".gitattributes": true}}
+
+	var pulls []GithubPullRequest
+	for n := 1; n <= 3; n++ {
+		pulls = append(pulls, GithubPullRequest{Number: githubv4.Int(n), BaseRefName: "base", Mergeable: githubv4.MergeableStateConflicting})
+	}
+
+	results := checkConflicts(pulls)
+
+	if r := results[0]; r.state != conflictStateClean || r.err != nil {
+		t.Errorf("pull request 1 should have merged: %+v", r)
+	}
+
+	if r := results[1]; r.state != conflictStateConflicting || r.err != nil || len(r.files) != 1 {
+		t.Errorf("pull request 2 should have conflicted: %+v %v", r, r.err)
+	}
+
+	if r := results[2]; r.state != conflictStateUnknown || r.err == nil {
+		t.Errorf("a pull request that cannot be fetched should have been reported as an error: %+v", r)
+	}
+}
+
+// concurrentGitProvider is a gitProvider that records the maximum number of merges in progress at once
+type concurrentGitProvider struct {
+	mu        sync.Mutex
+	conflicts map[string]bool
+	running   int32
+	max       int32
+}
+
+func (c *concurrentGitProvider) AddWorktree(path, ref string) error        { return nil }
+func (c *concurrentGitProvider) Fetch(depth int, refspecs ...string) error { return nil }
+func (c *concurrentGitProvider) In(dir string) gitProvider                 { return c }
+func (c *concurrentGitProvider) MergeTree(base, head string) ([]ConflictFile, error) {
+	return c.Merge(head)
+}
+func (c *concurrentGitProvider) RemoveWorktree(path string) error { return nil }
+
+func (c *concurrentGitProvider) Merge(ref string, args ...string) ([]ConflictFile, error) {
+
+	n := atomic.AddInt32(&c.running, 1)
+	defer atomic.AddInt32(&c.running, -1)
+
+	c.mu.Lock()
+	if n > c.max {
+		c.max = n
+	}
+	c.mu.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	if c.conflicts[ref] {
+		return nil, errMergeConflict
+	}
+
+	return nil, nil
+}
+
+func TestCheckConflicts(t *testing.T) {
+
+	defer services.reset()
+	defer config.Reset()
+
+	config.GlobalSet(config.GitConcurrency, "2")
+
+	services.f = mockFilesProvider{files: map[string]bool{".gitattributes": true}}
+	g := &concurrentGitProvider{conflicts: map[string]bool{"refs/prwatch/pull/2": true, "refs/prwatch/pull/4": true}}
+	services.g = g
+
+	var pulls []GithubPullRequest
+	for i, head := range []string{"a", "b", "c", "d", "e"} {
+		pulls = append(pulls, GithubPullRequest{
+			Number:      githubv4.Int(i + 1),
+			BaseRefName: "master",
+			HeadRefName: githubv4.String(head),
+			Mergeable:   githubv4.MergeableStateConflicting,
+		})
+	}
+
+	results := checkConflicts(pulls)
+
+	expected := []conflictState{
+		conflictStateClean,
+		conflictStateConflicting,
+		conflictStateClean,
+		conflictStateConflicting,
+		conflictStateClean,
+	}
+	for i, r := range results {
+		if !r.localMerge {
+			t.Errorf("pull request %d should have been merged locally", i+1)
+		}
+
+		if r.state != expected[i] {
+			t.Errorf("pull request %d: expected %v, got %v", i+1, expected[i], r.state)
+		}
+	}
+
+	if g.max > 2 {
+		t.Errorf("expected at most 2 concurrent merges, got %d", g.max)
+	}
 }
@@ -5,8 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"regexp"
 	"strings"
+	"time"
 
 	"github.com/acaloiaro/prwatch/internal/config"
 	"github.com/shurcooL/githubv4"
@@ -17,6 +17,14 @@ const defaultPageSize = 100
 
 type actor struct {
 	Login githubv4.String
+	// Typename is 'Bot' for Github Apps, e.g. Dependabot and Renovate
+	Typename githubv4.String `graphql:"__typename"`
+}
+
+type labels struct {
+	Nodes []struct {
+		Name githubv4.String
+	}
 }
 
 // GithubPullRequest contains all the relevant information about Github pull requests
@@ -25,6 +33,10 @@ type GithubPullRequest struct {
 	BaseRefName githubv4.String
 	BodyText    githubv4.String
 	HeadRefName githubv4.String
+	HeadRefOid  githubv4.GitObjectID
+	ID          githubv4.ID
+	IsDraft     githubv4.Boolean
+	Labels      labels `graphql:"labels(first: 100)"`
 	Mergeable   githubv4.MergeableState
 	Number      githubv4.Int
 	Title       githubv4.String
@@ -53,11 +65,20 @@ type pullRequests struct {
 
 type pullRequestQuery struct {
 	Repository struct {
-		PullRequests pullRequests `graphql:"pullRequests(states: [OPEN], first: $pageSize, orderBy: {field: UPDATED_AT, direction: ASC}, after: $pullsCursor)"`
+		PullRequests pullRequests `graphql:"pullRequests(states: [OPEN], first: $pageSize, orderBy: {field: UPDATED_AT, direction: ASC}, after: $pullsCursor, baseRefName: $baseRefName)"`
 	} `graphql:"repository(owner: $owner, name: $repository)"`
 }
 
-// ListPulls lists all open pulls requests for the current repository
+// labeledPullRequestQuery lists pull requests with any of $labels. Github rejects a null list of labels, so the
+// argument is only given when labels are configured.
+type labeledPullRequestQuery struct {
+	Repository struct {
+		PullRequests pullRequests `graphql:"pullRequests(states: [OPEN], first: $pageSize, orderBy: {field: UPDATED_AT, direction: ASC}, after: $pullsCursor, labels: $labels, baseRefName: $baseRefName)"`
+	} `graphql:"repository(owner: $owner, name: $repository)"`
+}
+
+// ListPulls lists the open pulls requests for the current repository that are watched. Filters are applied by Github
+// where possible.
 func ListPulls(client GithubQueryer) (pulls []GithubPullRequest, err error) {
 	o, repository, err := repositoryDetails()
 	if err != nil {
@@ -71,62 +92,155 @@ func ListPulls(client GithubQueryer) (pulls []GithubPullRequest, err error) {
 		"pageSize":    githubv4.Int(defaultPageSize),
 	}
 
+	labels, base := pullQueryFilters()
+	variables["baseRefName"] = base
+
 	var query pullRequestQuery
+	var labeledQuery labeledPullRequestQuery
+	q, page := interface{}(&query), &query.Repository.PullRequests
+	if len(labels) > 0 {
+		variables["labels"] = labels
+		q, page = &labeledQuery, &labeledQuery.Repository.PullRequests
+	}
+
 	for {
 
-		err = client.Query(&query, variables)
+		err = client.Query(q, variables)
 		if err != nil {
 			return
 		}
 
-		pulls = append(pulls, query.Repository.PullRequests.Nodes...)
+		now := time.Now()
+		for _, pr := range page.Nodes {
+			if ok, reason := watched(pr, now); !ok {
+				log.Printf("not watching pull request '%d': %s", pr.Number, reason)
+				continue
+			}
+
+			pulls = append(pulls, pr)
+		}
 
-		if !query.Repository.PullRequests.PageInfo.HasNextPage {
+		if !page.PageInfo.HasNextPage {
 			break
 		}
 
-		variables["pullsCursor"] = githubv4.NewString(query.Repository.PullRequests.PageInfo.EndCursor)
+		variables["pullsCursor"] = githubv4.NewString(page.PageInfo.EndCursor)
 	}
 
 	return
 }
 
-// hasConflict determines whether a pull request has a merge conflict
-func hasConflict(pr GithubPullRequest) bool {
+type pullRequestMergeableQuery struct {
+	Repository struct {
+		PullRequest struct {
+			Mergeable githubv4.MergeableState
+		} `graphql:"pullRequest(number: $number)"`
+	} `graphql:"repository(owner: $owner, name: $repository)"`
+}
 
-	if pr.Mergeable == githubv4.MergeableStateUnknown {
-		log.Println("Unable to determine pull request's mergable state. Consider increasing config.yml: dual_pass.wait_duration " +
-			"to give Github more time to calculate mergable state.")
-		return false
+// pullMergeableState fetches the mergeable state of a single pull request
+func pullMergeableState(client GithubQueryer, number int) (state githubv4.MergeableState, err error) {
+	o, repository, err := repositoryDetails()
+	if err != nil {
+		return
 	}
 
-	if pr.Mergeable != githubv4.MergeableStateConflicting {
-		return false
+	variables := map[string]interface{}{
+		"owner":      githubv4.String(o),
+		"repository": githubv4.String(repository),
+		"number":     githubv4.Int(number),
 	}
 
-	// when a pr's mergable state is conflicting and no .gitattributes exists, there is no chance it is mergeable
-	if !services.files().Exists(".gitattributes") {
-		return true
-	}
+	var query pullRequestMergeableQuery
+	err = client.Query(&query, variables)
+	state = query.Repository.PullRequest.Mergeable
+
+	return
+}
+
+// conflictState is whether a pull request has a merge conflict
+type conflictState string
+
+const (
+	// conflictStateClean: the pull request can be merged without conflicts
+	conflictStateClean conflictState = "clean"
+	// conflictStateConflicting: the pull request has a merge conflict
+	conflictStateConflicting conflictState = "conflicting"
+	// conflictStateUnknown: whether the pull request has a merge conflict could not be determined
+	conflictStateUnknown conflictState = "unknown"
+)
 
-	return !tryMerge(pr)
+// conflictCheck is the result of checking a pull request for conflicts
+type conflictCheck struct {
+	// state is whether the pull request has a merge conflict
+	state conflictState
+	// localMerge is whether the pull request was merged locally to determine whether it has a merge conflict
+	localMerge bool
+	// files are the files that conflicted when the pull request was merged locally
+	files []ConflictFile
+	// err is why the pull request could not be merged locally, in which case whether it conflicts is unknown
+	err error
 }
 
-// IssueID determines the "issue" associated with a pull request
-func IssueID(pr GithubPullRequest) (issueID string, ok bool) {
+// checkConflict checks whether a pull request has a merge conflict
+//
+// When Github has not determined a pull request's mergeable state, its state is unknown, unless
+// settings.git.merge_unknown is enabled, in which case it is merged locally instead.
+func checkConflict(pr GithubPullRequest) (c conflictCheck) {
+
+	if !mergesLocally(pr) {
+		switch pr.Mergeable {
+		case githubv4.MergeableStateMergeable:
+			c.state = conflictStateClean
+		case githubv4.MergeableStateConflicting:
+			c.state = conflictStateConflicting
+		default:
+			log.Println("Unable to determine pull request's mergable state. Consider increasing config.yml: dual_pass.wait_duration " +
+				"to give Github more time to calculate mergable state, or enabling settings.git.merge_unknown.")
+			c.state = conflictStateUnknown
+		}
+
+		return
+	}
+
+	if pr.Mergeable != githubv4.MergeableStateConflicting {
+		log.Printf("Github has not determined the mergeable state of pull request '%d', merging it locally", pr.Number)
+	}
 
-	if len(string(pr.BodyText)) == 0 {
+	c.localMerge = true
+
+	merged, files, err := tryMerge(pr)
+	if err != nil {
+		log.Printf("unable to merge pull request '%d' locally: %v", pr.Number, err)
+		c.state = conflictStateUnknown
+		c.err = err
 		return
 	}
 
-	// TODO: Decouple IssueId from Jira settings
-	re := regexp.MustCompile(fmt.Sprintf("%s-\\d*", config.GetString(config.JiraProjectName)))
-	issueID = re.FindString(string(pr.BodyText))
-	ok = issueID != ""
+	c.state = conflictStateConflicting
+	if merged {
+		c.state = conflictStateClean
+	}
+
+	c.files = files
 
 	return
 }
 
+// mergesLocally reports whether a pull request must be merged locally to tell whether it has a merge conflict
+func mergesLocally(pr GithubPullRequest) bool {
+
+	switch pr.Mergeable {
+	case githubv4.MergeableStateMergeable:
+		return false
+	case githubv4.MergeableStateConflicting:
+		// when a pr's mergable state is conflicting and no .gitattributes exists, there is no chance it is mergeable
+		return services.files().Exists(".gitattributes")
+	default:
+		return config.SettingEnabled(config.GitMergeUnknown)
+	}
+}
+
 func repositoryDetails() (owner, repository string, err error) {
 
 	repoDetails := config.GetEnv("GITHUB_REPOSITORY")
@@ -143,9 +257,10 @@ func repositoryDetails() (owner, repository string, err error) {
 	return
 }
 
-// GithubQueryer is an interface for performing github v4 graphql queries
+// GithubQueryer is an interface for performing github v4 graphql queries and mutations
 type GithubQueryer interface {
 	Query(query interface{}, variables map[string]interface{}) error
+	Mutate(mutation interface{}, input githubv4.Input, variables map[string]interface{}) error
 }
 
 type githubClient struct {
@@ -172,3 +287,48 @@ func NewGithubClient() (client GithubQueryer) {
 func (c *githubClient) Query(query interface{}, variables map[string]interface{}) error {
 	return c.v4Client.Query(c.ctx, query, variables)
 }
+
+// Mutate performs mutations against the github v4 graphql API
+func (c *githubClient) Mutate(mutation interface{}, input githubv4.Input, variables map[string]interface{}) error {
+	return c.v4Client.Mutate(c.ctx, mutation, input, variables)
+}
+
+type repositoryFileQuery struct {
+	Repository struct {
+		Object struct {
+			Blob struct {
+				Text githubv4.String
+			} `graphql:"... on Blob"`
+		} `graphql:"object(expression: $expression)"`
+	} `graphql:"repository(owner: $owner, name: $repository)"`
+}
+
+// RepositoryFileReader reads files from the default branch of Github repositories. Files that do not exist are read
+// as nil.
+func RepositoryFileReader(client GithubQueryer) config.RepositoryFileReader {
+	return func(repository, path string) (b []byte, err error) {
+
+		details := strings.Split(repository, "/")
+		if len(details) != 2 {
+			err = fmt.Errorf("invalid repository: '%s'. e.g. 'owner/name'", repository)
+			return
+		}
+
+		variables := map[string]interface{}{
+			"owner":      githubv4.String(details[0]),
+			"repository": githubv4.String(details[1]),
+			"expression": githubv4.String("HEAD:" + path),
+		}
+
+		var query repositoryFileQuery
+		if err = client.Query(&query, variables); err != nil {
+			return
+		}
+
+		if text := query.Repository.Object.Blob.Text; text != "" {
+			b = []byte(text)
+		}
+
+		return
+	}
+}
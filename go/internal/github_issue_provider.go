@@ -0,0 +1,424 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/acaloiaro/prwatch/internal/config"
+	"github.com/shurcooL/githubv4"
+)
+
+// Hidden markers appended to every comment prwatch leaves on Github, allowing prwatch to recognize its own comments,
+// and whether they report a conflict or its resolution
+const (
+	githubCommentMarker  = "<!-- prwatch"
+	githubConflictMarker = githubCommentMarker + ":conflict -->"
+	githubResolvedMarker = githubCommentMarker + ":resolved -->"
+)
+
+// githubIssueRef matches Github issue references, e.g. "#123"
+var githubIssueRef = regexp.MustCompile(`#\d+`)
+
+type githubLabel struct {
+	ID   githubv4.ID
+	Name githubv4.String
+}
+
+type githubIssueComment struct {
+	Body githubv4.String
+}
+
+type githubProjectCard struct {
+	ID     githubv4.ID
+	Column *struct {
+		ID githubv4.ID
+	}
+	Project struct {
+		ID githubv4.ID
+	}
+}
+
+type githubIssue struct {
+	ID     githubv4.ID
+	Number githubv4.Int
+	State  githubv4.IssueState
+	Labels struct {
+		Nodes []githubLabel
+	} `graphql:"labels(first: 100)"`
+	Comments struct {
+		Nodes []githubIssueComment
+	} `graphql:"comments(last: 10)"`
+	ProjectCards struct {
+		Nodes []githubProjectCard
+	} `graphql:"projectCards(first: 100)"`
+}
+
+type githubIssueQuery struct {
+	Repository struct {
+		Issue githubIssue `graphql:"issue(number: $number)"`
+	} `graphql:"repository(owner: $owner, name: $repository)"`
+}
+
+type githubLabelQuery struct {
+	Repository struct {
+		Label *struct {
+			ID githubv4.ID
+		} `graphql:"label(name: $name)"`
+	} `graphql:"repository(owner: $owner, name: $repository)"`
+}
+
+type githubProjectColumnQuery struct {
+	Node struct {
+		ProjectColumn struct {
+			Project struct {
+				ID githubv4.ID
+			}
+		} `graphql:"... on ProjectColumn"`
+	} `graphql:"node(id: $id)"`
+}
+
+type githubIssueProvider struct {
+	c GithubQueryer
+}
+
+func newGithubIssueProvider(c GithubQueryer) issueProvider {
+	g := githubIssueProvider{
+		c: c,
+	}
+
+	return &g
+}
+
+// CommentIssue comments on github issues, @mentioning the issue owner
+func (g *githubIssueProvider) CommentIssue(i issue) (ok bool) {
+
+	if !config.UserSettingEnabled(i.Owner, config.IssueComments) {
+		return
+	}
+
+	ghIssue, err := g.issue(i)
+	if err != nil {
+		log.Printf("unable to retrieve issue: '%s': %v", i.ID, err)
+		return
+	}
+
+	comment := g.genComment(i, ghIssue)
+	if comment == "" {
+		ok = true
+		return
+	}
+
+	err = g.addComment(ghIssue, comment)
+	if err != nil {
+		log.Printf("unable to leave comment on issue: '%s': %v", i.ID, err)
+	}
+
+	ok = err == nil
+
+	return
+}
+
+// ResolveIssue removes settings.github.conflict_label from issues that were previously in conflict, and comments that
+// the conflict has been resolved.
+//
+// Issues are considered to have previously been in conflict when they are labeled with settings.github.conflict_label,
+// or prwatch's most recent comment on them reported a conflict.
+func (g *githubIssueProvider) ResolveIssue(i issue) (ok bool) {
+
+	ghIssue, err := g.issue(i)
+	if err != nil {
+		log.Printf("unable to retrieve issue: '%s': %v", i.ID, err)
+		return
+	}
+
+	label := config.GetString(config.GithubConflictLabel)
+	labeled := label != "" && hasLabel(ghIssue, label)
+	if !labeled && lastCommentMarker(ghIssue) != githubConflictMarker {
+		return
+	}
+
+	log.Printf("conflict resolved for issue: %s", i.ID)
+
+	ok = true
+	if labeled && config.UserSettingEnabled(i.Owner, config.IssueTransitions) {
+		if err = g.removeLabel(ghIssue, label); err != nil {
+			log.Printf("unable to remove label '%s' from issue '%s': %v", label, i.ID, err)
+			ok = false
+		}
+	}
+
+	if !config.UserSettingEnabled(i.Owner, config.IssueComments) || lastCommentMarker(ghIssue) == githubResolvedMarker {
+		return
+	}
+
+	comment := fmt.Sprintf("%s\n%s", issueResolvedComment(newIssueCommentData(i, "@"+i.Owner, "", "")), githubResolvedMarker)
+	if err = g.addComment(ghIssue, comment); err != nil {
+		log.Printf("unable to leave comment on issue: '%s': %v", i.ID, err)
+		ok = false
+	}
+
+	return
+}
+
+// TransitionIssue "transitions" github issues by applying settings.github.conflict_label and/or adding them to the
+// project column settings.github.conflict_column_id
+func (g *githubIssueProvider) TransitionIssue(i issue) (ok bool) {
+
+	if !config.UserSettingEnabled(i.Owner, config.IssueTransitions) {
+		return
+	}
+
+	label := config.GetString(config.GithubConflictLabel)
+	columnID := config.GetString(config.GithubConflictColumn)
+	if label == "" && columnID == "" {
+		log.Println(config.CheckMessage(config.GithubConflictLabel, "e.g. 'merge conflict'"))
+		return
+	}
+
+	ghIssue, err := g.issue(i)
+	if err != nil {
+		log.Printf("unable to retrieve issue: '%s': %v", i.ID, err)
+		return
+	}
+
+	if ghIssue.State != githubv4.IssueStateOpen {
+		log.Printf("Not transitioning closed issue: %s.", i.ID)
+		return
+	}
+
+	if label != "" && !hasLabel(ghIssue, label) {
+		if err = g.addLabel(ghIssue, label); err != nil {
+			log.Printf("unable to label issue '%s' with '%s': %v", i.ID, label, err)
+			return
+		}
+	}
+
+	if columnID != "" {
+		if err = g.addToColumn(ghIssue, columnID); err != nil {
+			log.Printf("unable to add issue '%s' to project column '%s': %v", i.ID, columnID, err)
+			return
+		}
+	}
+
+	ok = true
+	return
+}
+
+func (g *githubIssueProvider) issue(i issue) (ghIssue githubIssue, err error) {
+
+	number, err := githubIssueNumber(i.ID)
+	if err != nil {
+		return
+	}
+
+	o, repository, err := repositoryDetails()
+	if err != nil {
+		return
+	}
+
+	variables := map[string]interface{}{
+		"owner":      githubv4.String(o),
+		"repository": githubv4.String(repository),
+		"number":     githubv4.Int(number),
+	}
+
+	var query githubIssueQuery
+	err = g.c.Query(&query, variables)
+	if err != nil {
+		return
+	}
+
+	ghIssue = query.Repository.Issue
+
+	return
+}
+
+func (g *githubIssueProvider) addLabel(ghIssue githubIssue, label string) (err error) {
+
+	o, repository, err := repositoryDetails()
+	if err != nil {
+		return
+	}
+
+	variables := map[string]interface{}{
+		"owner":      githubv4.String(o),
+		"repository": githubv4.String(repository),
+		"name":       githubv4.String(label),
+	}
+
+	var query githubLabelQuery
+	err = g.c.Query(&query, variables)
+	if err != nil {
+		return
+	}
+
+	if query.Repository.Label == nil {
+		return fmt.Errorf("label does not exist. %s", config.CheckMessage(config.GithubConflictLabel))
+	}
+
+	var m struct {
+		AddLabelsToLabelable struct {
+			ClientMutationID githubv4.String
+		} `graphql:"addLabelsToLabelable(input: $input)"`
+	}
+
+	input := githubv4.AddLabelsToLabelableInput{
+		LabelableID: ghIssue.ID,
+		LabelIDs:    []githubv4.ID{query.Repository.Label.ID},
+	}
+
+	return g.c.Mutate(&m, input, nil)
+}
+
+func (g *githubIssueProvider) removeLabel(ghIssue githubIssue, label string) error {
+
+	var labelID githubv4.ID
+	for _, l := range ghIssue.Labels.Nodes {
+		if strings.EqualFold(string(l.Name), label) {
+			labelID = l.ID
+		}
+	}
+
+	var m struct {
+		RemoveLabelsFromLabelable struct {
+			ClientMutationID githubv4.String
+		} `graphql:"removeLabelsFromLabelable(input: $input)"`
+	}
+
+	input := githubv4.RemoveLabelsFromLabelableInput{
+		LabelableID: ghIssue.ID,
+		LabelIDs:    []githubv4.ID{labelID},
+	}
+
+	return g.c.Mutate(&m, input, nil)
+}
+
+func (g *githubIssueProvider) addComment(ghIssue githubIssue, body string) error {
+
+	var m struct {
+		AddComment struct {
+			ClientMutationID githubv4.String
+		} `graphql:"addComment(input: $input)"`
+	}
+
+	input := githubv4.AddCommentInput{
+		SubjectID: ghIssue.ID,
+		Body:      githubv4.String(body),
+	}
+
+	return g.c.Mutate(&m, input, nil)
+}
+
+// addToColumn adds an issue to a project column. Issues that already have a card in the column's project have their
+// card moved to the column instead, since Github allows only one card per issue in each project.
+func (g *githubIssueProvider) addToColumn(ghIssue githubIssue, columnID string) error {
+
+	cards := ghIssue.ProjectCards.Nodes
+	for _, card := range cards {
+		if card.Column != nil && card.Column.ID == githubv4.ID(columnID) {
+			return nil
+		}
+	}
+
+	if len(cards) > 0 {
+		var query githubProjectColumnQuery
+		if err := g.c.Query(&query, map[string]interface{}{"id": githubv4.ID(columnID)}); err != nil {
+			return err
+		}
+
+		for _, card := range cards {
+			if card.Project.ID == query.Node.ProjectColumn.Project.ID {
+				return g.moveCard(card, columnID)
+			}
+		}
+	}
+
+	var m struct {
+		AddProjectCard struct {
+			ClientMutationID githubv4.String
+		} `graphql:"addProjectCard(input: $input)"`
+	}
+
+	contentID := ghIssue.ID
+	input := githubv4.AddProjectCardInput{
+		ProjectColumnID: githubv4.ID(columnID),
+		ContentID:       &contentID,
+	}
+
+	return g.c.Mutate(&m, input, nil)
+}
+
+func (g *githubIssueProvider) moveCard(card githubProjectCard, columnID string) error {
+
+	var m struct {
+		MoveProjectCard struct {
+			ClientMutationID githubv4.String
+		} `graphql:"moveProjectCard(input: $input)"`
+	}
+
+	input := githubv4.MoveProjectCardInput{
+		CardID:   card.ID,
+		ColumnID: githubv4.ID(columnID),
+	}
+
+	return g.c.Mutate(&m, input, nil)
+}
+
+func (g *githubIssueProvider) genComment(i issue, ghIssue githubIssue) string {
+
+	// only comment on issues when prwatch has not already reported the conflict
+	if lastCommentMarker(ghIssue) == githubConflictMarker {
+		return ""
+	}
+
+	var status, statusChangeMsg string
+	label := config.GetString(config.GithubConflictLabel)
+	statusChanging := label != "" && !hasLabel(ghIssue, label) && config.UserSettingEnabled(i.Owner, config.IssueTransitions)
+	if statusChanging {
+		status = label
+		statusChangeMsg = fmt.Sprintf("This issue has been labeled: '%s'.", label)
+	}
+
+	return fmt.Sprintf("%s\n%s", issueConflictComment(newIssueCommentData(i, "@"+i.Owner, status, statusChangeMsg)), githubConflictMarker)
+}
+
+// lastCommentMarker returns the marker of the most recent comment prwatch left on an issue, if any
+func lastCommentMarker(ghIssue githubIssue) (marker string) {
+
+	for _, c := range ghIssue.Comments.Nodes {
+		body := string(c.Body)
+		switch {
+		case strings.Contains(body, githubConflictMarker):
+			marker = githubConflictMarker
+		case strings.Contains(body, githubResolvedMarker):
+			marker = githubResolvedMarker
+		}
+	}
+
+	return
+}
+
+func hasLabel(ghIssue githubIssue, label string) bool {
+
+	for _, l := range ghIssue.Labels.Nodes {
+		if strings.EqualFold(string(l.Name), label) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// githubIssueNumber parses issue references of the form "#123"
+func githubIssueNumber(issueID string) (number int, err error) {
+
+	number, err = strconv.Atoi(strings.TrimPrefix(issueID, "#"))
+	if err != nil {
+		err = fmt.Errorf("invalid github issue reference: '%s'", issueID)
+	}
+
+	return
+}
@@ -0,0 +1,195 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/acaloiaro/prwatch/internal/config"
+	"github.com/shurcooL/githubv4"
+)
+
+func mockGithubIssueClient(ghIssue githubIssue) *MockGithubClient {
+	return &MockGithubClient{f: func(query interface{}, v map[string]interface{}) error {
+		switch q := query.(type) {
+		case *githubIssueQuery:
+			q.Repository.Issue = ghIssue
+		case *githubLabelQuery:
+			q.Repository.Label = &struct{ ID githubv4.ID }{ID: "label-id"}
+		case *githubProjectColumnQuery:
+			q.Node.ProjectColumn.Project.ID = "project-id"
+		}
+
+		return nil
+	}}
+}
+
+func TestGithubIssueComment(t *testing.T) {
+
+	config.SetEnv("GITHUB_REPOSITORY", "acaloiaro/isok")
+	config.GlobalEnable(config.IssueComments)
+
+	var body string
+	client := mockGithubIssueClient(githubIssue{ID: "issue-id", State: githubv4.IssueStateOpen})
+	client.mutateFunc = func(m interface{}, input githubv4.Input) error {
+		body = string(input.(githubv4.AddCommentInput).Body)
+		return nil
+	}
+
+	p := newGithubIssueProvider(client)
+	if ok := p.CommentIssue(issue{ID: "#42", Owner: "acaloiaro"}); !ok {
+		t.Error("issue should have been commented on")
+	}
+
+	if !strings.HasPrefix(body, "@acaloiaro") {
+		t.Errorf("comment should @mention the issue owner: %s", body)
+	}
+
+	// issues that prwatch already commented on should not be commented on again
+	commented := githubIssue{ID: "issue-id", State: githubv4.IssueStateOpen}
+	commented.Comments.Nodes = append(commented.Comments.Nodes, githubIssueComment{Body: githubConflictMarker})
+
+	body = ""
+	client = mockGithubIssueClient(commented)
+	client.mutateFunc = func(m interface{}, input githubv4.Input) error {
+		body = string(input.(githubv4.AddCommentInput).Body)
+		return nil
+	}
+
+	p = newGithubIssueProvider(client)
+	if ok := p.CommentIssue(issue{ID: "#42", Owner: "acaloiaro"}); !ok || body != "" {
+		t.Error("issue should not have been commented on twice")
+	}
+}
+
+func TestGithubTransitionIssue(t *testing.T) {
+
+	config.SetEnv("GITHUB_REPOSITORY", "acaloiaro/isok")
+	config.GlobalEnable(config.IssueTransitions)
+	config.GlobalSet(config.GithubConflictLabel, "merge conflict")
+	defer config.GlobalSet(config.GithubConflictLabel, "")
+
+	var labeled bool
+	client := mockGithubIssueClient(githubIssue{ID: "issue-id", State: githubv4.IssueStateOpen})
+	client.mutateFunc = func(m interface{}, input githubv4.Input) error {
+		in := input.(githubv4.AddLabelsToLabelableInput)
+		labeled = in.LabelableID == "issue-id" && in.LabelIDs[0] == "label-id"
+		return nil
+	}
+
+	p := newGithubIssueProvider(client)
+	if ok := p.TransitionIssue(issue{ID: "#42", Owner: "acaloiaro"}); !ok || !labeled {
+		t.Error("issue should have been labeled")
+	}
+
+	// closed issues are not transitioned
+	labeled = false
+	client = mockGithubIssueClient(githubIssue{ID: "issue-id", State: githubv4.IssueStateClosed})
+	client.mutateFunc = func(m interface{}, input githubv4.Input) error {
+		labeled = true
+		return nil
+	}
+
+	p = newGithubIssueProvider(client)
+	if ok := p.TransitionIssue(issue{ID: "#42", Owner: "acaloiaro"}); ok || labeled {
+		t.Error("closed issues should not be transitioned")
+	}
+}
+
+func TestGithubTransitionIssueColumn(t *testing.T) {
+
+	config.SetEnv("GITHUB_REPOSITORY", "acaloiaro/isok")
+	config.GlobalEnable(config.IssueTransitions)
+	config.GlobalSet(config.GithubConflictColumn, "column-id")
+	defer config.GlobalSet(config.GithubConflictColumn, "")
+
+	card := func(id, project, column string) githubProjectCard {
+		c := githubProjectCard{ID: githubv4.ID(id)}
+		c.Project.ID = project
+		c.Column = &struct{ ID githubv4.ID }{ID: column}
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		cards    []githubProjectCard
+		expected interface{}
+	}{
+		{"issues without cards are added to the column", nil, githubv4.AddProjectCardInput{}},
+		{"issues with cards in other projects are added to the column", []githubProjectCard{card("card-id", "other-project-id", "other-column-id")}, githubv4.AddProjectCardInput{}},
+		{"issues with cards in the column's project have their card moved", []githubProjectCard{card("card-id", "project-id", "other-column-id")}, githubv4.MoveProjectCardInput{}},
+		{"issues already in the column are left there", []githubProjectCard{card("card-id", "project-id", "column-id")}, nil},
+	}
+
+	for _, test := range tests {
+		ghIssue := githubIssue{ID: "issue-id", State: githubv4.IssueStateOpen}
+		ghIssue.ProjectCards.Nodes = test.cards
+
+		var mutated interface{}
+		client := mockGithubIssueClient(ghIssue)
+		client.mutateFunc = func(m interface{}, input githubv4.Input) error {
+			switch in := input.(type) {
+			case githubv4.AddProjectCardInput:
+				mutated = githubv4.AddProjectCardInput{}
+			case githubv4.MoveProjectCardInput:
+				if in.CardID != "card-id" || in.ColumnID != "column-id" {
+					t.Errorf("%s: unexpected card move: %+v", test.name, in)
+				}
+				mutated = githubv4.MoveProjectCardInput{}
+			}
+			return nil
+		}
+
+		p := newGithubIssueProvider(client)
+		if ok := p.TransitionIssue(issue{ID: "#42", Owner: "acaloiaro"}); !ok || mutated != test.expected {
+			t.Errorf("%s: expected: %T, got: %T", test.name, test.expected, mutated)
+		}
+	}
+}
+
+func TestGithubResolveIssue(t *testing.T) {
+
+	config.SetEnv("GITHUB_REPOSITORY", "acaloiaro/isok")
+	config.GlobalEnable(config.IssueComments)
+	config.GlobalEnable(config.IssueTransitions)
+	config.GlobalSet(config.GithubConflictLabel, "merge conflict")
+	defer config.GlobalSet(config.GithubConflictLabel, "")
+
+	// issues that were never in conflict are not resolved
+	mutated := false
+	client := mockGithubIssueClient(githubIssue{ID: "issue-id", State: githubv4.IssueStateOpen})
+	client.mutateFunc = func(m interface{}, input githubv4.Input) error {
+		mutated = true
+		return nil
+	}
+
+	p := newGithubIssueProvider(client)
+	if ok := p.ResolveIssue(issue{ID: "#42", Owner: "acaloiaro"}); ok || mutated {
+		t.Error("issues that were never in conflict should not be resolved")
+	}
+
+	// issues labeled as in conflict are unlabeled and commented on
+	conflicting := githubIssue{ID: "issue-id", State: githubv4.IssueStateOpen}
+	conflicting.Labels.Nodes = append(conflicting.Labels.Nodes, githubLabel{ID: "label-id", Name: "merge conflict"})
+
+	var unlabeled bool
+	var comment string
+	client = mockGithubIssueClient(conflicting)
+	client.mutateFunc = func(m interface{}, input githubv4.Input) error {
+		switch in := input.(type) {
+		case githubv4.RemoveLabelsFromLabelableInput:
+			unlabeled = in.LabelIDs[0] == "label-id"
+		case githubv4.AddCommentInput:
+			comment = string(in.Body)
+		}
+		return nil
+	}
+
+	p = newGithubIssueProvider(client)
+	if ok := p.ResolveIssue(issue{ID: "#42", Owner: "acaloiaro"}); !ok || !unlabeled {
+		t.Error("the conflict label should have been removed")
+	}
+
+	if !strings.Contains(comment, githubResolvedMarker) {
+		t.Errorf("the resolution should have been commented on: %s", comment)
+	}
+}
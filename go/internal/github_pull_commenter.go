@@ -0,0 +1,179 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/acaloiaro/prwatch/internal/config"
+	"github.com/shurcooL/githubv4"
+)
+
+// pullCommenter is an interface for commenting directly on pull requests. Unlike issueProvider, it requires no issue
+// tracker, so pull requests without an associated issue are still reported.
+type pullCommenter interface {
+	CommentPull(pr GithubPullRequest, files []ConflictFile) (ok bool)
+	ResolvePull(pr GithubPullRequest) (ok bool)
+}
+
+type githubPullComment struct {
+	ID              githubv4.ID
+	Body            githubv4.String
+	ViewerDidAuthor githubv4.Boolean
+}
+
+type githubPullCommentsQuery struct {
+	Repository struct {
+		PullRequest struct {
+			Comments struct {
+				Nodes []githubPullComment
+			} `graphql:"comments(last: 100)"`
+		} `graphql:"pullRequest(number: $number)"`
+	} `graphql:"repository(owner: $owner, name: $repository)"`
+}
+
+type githubPullCommenter struct {
+	c GithubQueryer
+}
+
+func newGithubPullCommenter(c GithubQueryer) pullCommenter {
+	g := githubPullCommenter{
+		c: c,
+	}
+
+	return &g
+}
+
+// CommentPull leaves a single comment on a conflicting pull request, @mentioning its author. When prwatch has already
+// commented on the pull request, its existing comment is edited rather than leaving a new one. Conflicting files are
+// listed in the comment, when they are known.
+func (g *githubPullCommenter) CommentPull(pr GithubPullRequest, files []ConflictFile) (ok bool) {
+
+	author := string(pr.Author.Login)
+	if !config.UserSettingEnabled(author, config.PullComments) {
+		return
+	}
+
+	existing, err := g.existingComment(pr)
+	if err != nil {
+		log.Printf("unable to retrieve comments for pull request '%d': %v", pr.Number, err)
+		return
+	}
+
+	body := g.genComment(pr, files)
+
+	if existing == nil {
+		err = g.addComment(pr, body)
+	} else if string(existing.Body) != body {
+		err = g.updateComment(*existing, body)
+	}
+
+	if err != nil {
+		log.Printf("unable to leave comment on pull request '%d': %v", pr.Number, err)
+	}
+
+	ok = err == nil
+
+	return
+}
+
+// ResolvePull edits prwatch's conflict comment on a pull request to report that the conflict has been resolved.
+// Pull requests that prwatch never reported as conflicting are left untouched.
+func (g *githubPullCommenter) ResolvePull(pr GithubPullRequest) (ok bool) {
+
+	author := string(pr.Author.Login)
+	if !config.UserSettingEnabled(author, config.PullComments) {
+		return
+	}
+
+	existing, err := g.existingComment(pr)
+	if err != nil {
+		log.Printf("unable to retrieve comments for pull request '%d': %v", pr.Number, err)
+		return
+	}
+
+	if existing == nil || !strings.Contains(string(existing.Body), githubConflictMarker) {
+		return
+	}
+
+	log.Printf("conflict resolved for pull request: %s", pr.URL)
+
+	err = g.updateComment(*existing, g.genResolvedComment(pr))
+	if err != nil {
+		log.Printf("unable to edit comment on pull request '%d': %v", pr.Number, err)
+	}
+
+	ok = err == nil
+
+	return
+}
+
+// existingComment finds the most recent comment prwatch left on a pull request, if any
+func (g *githubPullCommenter) existingComment(pr GithubPullRequest) (comment *githubPullComment, err error) {
+
+	o, repository, err := repositoryDetails()
+	if err != nil {
+		return
+	}
+
+	variables := map[string]interface{}{
+		"owner":      githubv4.String(o),
+		"repository": githubv4.String(repository),
+		"number":     pr.Number,
+	}
+
+	var query githubPullCommentsQuery
+	err = g.c.Query(&query, variables)
+	if err != nil {
+		return
+	}
+
+	for _, c := range query.Repository.PullRequest.Comments.Nodes {
+		if bool(c.ViewerDidAuthor) && strings.Contains(string(c.Body), githubCommentMarker) {
+			found := c
+			comment = &found
+		}
+	}
+
+	return
+}
+
+func (g *githubPullCommenter) addComment(pr GithubPullRequest, body string) error {
+
+	var m struct {
+		AddComment struct {
+			ClientMutationID githubv4.String
+		} `graphql:"addComment(input: $input)"`
+	}
+
+	input := githubv4.AddCommentInput{
+		SubjectID: pr.ID,
+		Body:      githubv4.String(body),
+	}
+
+	return g.c.Mutate(&m, input, nil)
+}
+
+func (g *githubPullCommenter) updateComment(comment githubPullComment, body string) error {
+
+	var m struct {
+		UpdateIssueComment struct {
+			ClientMutationID githubv4.String
+		} `graphql:"updateIssueComment(input: $input)"`
+	}
+
+	input := githubv4.UpdateIssueCommentInput{
+		ID:   comment.ID,
+		Body: githubv4.String(body),
+	}
+
+	return g.c.Mutate(&m, input, nil)
+}
+
+func (g *githubPullCommenter) genComment(pr GithubPullRequest, files []ConflictFile) string {
+	return fmt.Sprintf("%s\n%s", pullConflictComment(pr, files), githubConflictMarker)
+}
+
+func (g *githubPullCommenter) genResolvedComment(pr GithubPullRequest) string {
+	return fmt.Sprintf("%s\n%s", pullResolvedComment(pr), githubResolvedMarker)
+}
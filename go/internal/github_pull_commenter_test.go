@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/acaloiaro/prwatch/internal/config"
+	"github.com/shurcooL/githubv4"
+)
+
+type mockPullCommenter struct {
+	commented []GithubPullRequest
+	resolved  []GithubPullRequest
+	// fail is whether comments fail
+	fail bool
+}
+
+func (m *mockPullCommenter) CommentPull(pr GithubPullRequest, files []ConflictFile) bool {
+	m.commented = append(m.commented, pr)
+	return !m.fail
+}
+
+func (m *mockPullCommenter) ResolvePull(pr GithubPullRequest) bool {
+	m.resolved = append(m.resolved, pr)
+	return true
+}
+
+func mockPullCommentsClient(comments ...githubPullComment) *MockGithubClient {
+	return &MockGithubClient{f: func(query interface{}, v map[string]interface{}) error {
+		q := query.(*githubPullCommentsQuery)
+		q.Repository.PullRequest.Comments.Nodes = comments
+
+		return nil
+	}}
+}
+
+func TestCommentPull(t *testing.T) {
+
+	config.SetEnv("GITHUB_REPOSITORY", "acaloiaro/isok")
+	config.GlobalEnable(config.PullComments)
+	defer config.GlobalDisable(config.PullComments)
+
+	pr := GithubPullRequest{ID: "pr-id", Number: 1, BaseRefName: "master", Author: actor{Login: "acaloiaro"}}
+
+	// prwatch has not yet commented on the pull request
+	var added githubv4.AddCommentInput
+	client := mockPullCommentsClient()
+	client.mutateFunc = func(m interface{}, input githubv4.Input) error {
+		added = input.(githubv4.AddCommentInput)
+		return nil
+	}
+
+	if ok := newGithubPullCommenter(client).CommentPull(pr, nil); !ok {
+		t.Error("pull request should have been commented on")
+	}
+
+	if added.SubjectID != "pr-id" || !strings.HasPrefix(string(added.Body), "@acaloiaro") {
+		t.Errorf("comment should be left on the pull request, @mentioning its author: %v", added)
+	}
+
+	// prwatch has already commented on the pull request, but the comment is out of date
+	var updated githubv4.UpdateIssueCommentInput
+	client = mockPullCommentsClient(githubPullComment{ID: "comment-id", Body: "stale " + githubCommentMarker, ViewerDidAuthor: true})
+	client.mutateFunc = func(m interface{}, input githubv4.Input) error {
+		updated = input.(githubv4.UpdateIssueCommentInput)
+		return nil
+	}
+
+	if ok := newGithubPullCommenter(client).CommentPull(pr, nil); !ok || updated.ID != "comment-id" {
+		t.Error("existing pull request comment should have been edited")
+	}
+
+	// prwatch's comment is up to date
+	mutated := false
+	c := &githubPullCommenter{}
+	client = mockPullCommentsClient(githubPullComment{ID: "comment-id", Body: githubv4.String(c.genComment(pr, nil)), ViewerDidAuthor: true})
+	client.mutateFunc = func(m interface{}, input githubv4.Input) error {
+		mutated = true
+		return nil
+	}
+
+	if ok := newGithubPullCommenter(client).CommentPull(pr, nil); !ok || mutated {
+		t.Error("up to date pull request comments should not be edited")
+	}
+}
+
+func TestResolvePull(t *testing.T) {
+
+	config.SetEnv("GITHUB_REPOSITORY", "acaloiaro/isok")
+	config.GlobalEnable(config.PullComments)
+	defer config.GlobalDisable(config.PullComments)
+
+	pr := GithubPullRequest{ID: "pr-id", Number: 1, BaseRefName: "master", Author: actor{Login: "acaloiaro"}}
+
+	// pull requests that were never reported as conflicting are left untouched
+	mutated := false
+	client := mockPullCommentsClient()
+	client.mutateFunc = func(m interface{}, input githubv4.Input) error {
+		mutated = true
+		return nil
+	}
+
+	if ok := newGithubPullCommenter(client).ResolvePull(pr); ok || mutated {
+		t.Error("pull requests that were never in conflict should not be resolved")
+	}
+
+	// conflict comments are edited to report the resolution
+	var updated githubv4.UpdateIssueCommentInput
+	client = mockPullCommentsClient(githubPullComment{ID: "comment-id", Body: githubConflictMarker, ViewerDidAuthor: true})
+	client.mutateFunc = func(m interface{}, input githubv4.Input) error {
+		updated = input.(githubv4.UpdateIssueCommentInput)
+		return nil
+	}
+
+	if ok := newGithubPullCommenter(client).ResolvePull(pr); !ok || !strings.Contains(string(updated.Body), githubResolvedMarker) {
+		t.Error("conflict comment should have been edited to report the resolution")
+	}
+}
@@ -0,0 +1,80 @@
+package internal
+
+import (
+	"log"
+	"strings"
+
+	"github.com/acaloiaro/prwatch/internal/config"
+	"github.com/shurcooL/githubv4"
+)
+
+type teamMembersQuery struct {
+	Organization struct {
+		Team struct {
+			Members struct {
+				Nodes    []actor
+				PageInfo pageInfo
+			} `graphql:"members(first: $pageSize, after: $membersCursor)"`
+		} `graphql:"team(slug: $team)"`
+	} `graphql:"organization(login: $organization)"`
+}
+
+// FetchTeamMembers fetches the members of every team configured with a Github team, so that team settings apply to
+// them. Teams whose members cannot be fetched are left with their statically listed members. Reading team membership
+// requires a token with the 'read:org' scope.
+func FetchTeamMembers(client GithubQueryer) {
+
+	for _, team := range config.Teams() {
+		if team.Github == "" {
+			continue
+		}
+
+		members, err := githubTeamMembers(client, team.Github)
+		if err != nil {
+			log.Printf("unable to fetch members of Github team '%s': %v. %s", team.Github, err,
+				config.CheckMessage("teams."+team.Name+".github", "The token must have the 'read:org' scope."))
+			continue
+		}
+
+		config.SetTeamMembers(team.Name, members)
+	}
+}
+
+// githubTeamMembers lists the logins of a Github team's members. The team is named 'org/team-slug', or 'team-slug' for
+// a team in the repository owner's organization.
+func githubTeamMembers(client GithubQueryer, team string) (members []string, err error) {
+
+	organization, slug := "", team
+	if i := strings.Index(team, "/"); i >= 0 {
+		organization, slug = team[:i], team[i+1:]
+	} else if organization, _, err = repositoryDetails(); err != nil {
+		return
+	}
+
+	variables := map[string]interface{}{
+		"organization":  githubv4.String(organization),
+		"team":          githubv4.String(slug),
+		"membersCursor": (*githubv4.String)(nil),
+		"pageSize":      githubv4.Int(defaultPageSize),
+	}
+
+	var query teamMembersQuery
+	for {
+		err = client.Query(&query, variables)
+		if err != nil {
+			return
+		}
+
+		for _, member := range query.Organization.Team.Members.Nodes {
+			members = append(members, string(member.Login))
+		}
+
+		if !query.Organization.Team.Members.PageInfo.HasNextPage {
+			break
+		}
+
+		variables["membersCursor"] = githubv4.NewString(query.Organization.Team.Members.PageInfo.EndCursor)
+	}
+
+	return
+}
@@ -0,0 +1,56 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/acaloiaro/prwatch/internal/config"
+	"github.com/shurcooL/githubv4"
+)
+
+func TestFetchTeamMembers(t *testing.T) {
+
+	config.SetEnv("GITHUB_REPOSITORY", "acaloiaro/isok")
+	config.GlobalSet("teams.backend.github", "backend")
+	config.GlobalDisable("teams.backend.settings.pulls.enable_comment")
+	config.GlobalEnable(config.PullComments)
+	defer func() {
+		config.GlobalSet("teams.backend.github", "")
+		config.GlobalDisable(config.PullComments)
+		config.SetTeamMembers("backend", nil)
+	}()
+
+	pages := [][]actor{{{Login: "alice"}}, {{Login: "bob"}}}
+	client := &MockGithubClient{f: func(query interface{}, v map[string]interface{}) error {
+		q := query.(*teamMembersQuery)
+
+		if v["organization"] != githubv4.String("acaloiaro") || v["team"] != githubv4.String("backend") {
+			t.Errorf("teams without an organization should be found in the repository owner's: %v", v)
+		}
+
+		page := 0
+		if v["membersCursor"] != (*githubv4.String)(nil) {
+			page = 1
+		}
+
+		q.Organization.Team.Members.Nodes = pages[page]
+		q.Organization.Team.Members.PageInfo = pageInfo{EndCursor: "next", HasNextPage: page == 0}
+
+		return nil
+	}}
+
+	FetchTeamMembers(client)
+
+	for _, member := range []string{"alice", "bob"} {
+		if teams := config.TeamsOf(member); len(teams) != 1 || teams[0] != "backend" {
+			t.Errorf("'%s' should be a member of the backend team, got: %v", member, teams)
+		}
+
+		if config.UserSettingEnabled(member, config.PullComments) {
+			t.Errorf("the backend team's settings should apply to '%s'", member)
+		}
+	}
+
+	if !config.UserSettingEnabled("carol", config.PullComments) {
+		t.Error("team settings should not apply to users outside the team")
+	}
+}
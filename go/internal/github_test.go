@@ -42,14 +42,24 @@ func TestRepoDetails(t *testing.T) {
 }
 
 type MockGithubClient struct {
-	f         func(query interface{}, variables map[string]interface{}) error
-	pageCount int
+	f          func(query interface{}, variables map[string]interface{}) error
+	mutateFunc func(mutation interface{}, input githubv4.Input) error
+	pageCount  int
 }
 
 func (c *MockGithubClient) Query(query interface{}, variables map[string]interface{}) error {
 	return c.f(query, variables)
 }
 
+func (c *MockGithubClient) Mutate(mutation interface{}, input githubv4.Input, variables map[string]interface{}) error {
+
+	if c.mutateFunc != nil {
+		return c.mutateFunc(mutation, input)
+	}
+
+	return nil
+}
+
 func TestListPulls(t *testing.T) {
 
 	config.SetEnv("GITHUB_REPOSITORY", "acaloiaro/isok")
@@ -123,22 +133,7 @@ func TestListPulls(t *testing.T) {
 
 }
 
-func TestIssueId(t *testing.T) {
-
-	config.GlobalEnable(config.Jira)
-	config.GlobalSet(config.JiraProjectName, "FOO")
-
-	pr := GithubPullRequest{
-		BodyText: "Issue url is https://foobar.atlassian.net/browse/FOO-1234",
-	}
-
-	const expectedID = "FOO-1234"
-	if ID, ok := IssueID(pr); ID != expectedID || !ok {
-		t.Errorf("expected issue id: %s: got: %s", expectedID, ID)
-	}
-}
-
-func TestHasConflict(t *testing.T) {
+func TestCheckConflict(t *testing.T) {
 
 	defer services.reset()
 
@@ -149,24 +144,91 @@ func TestHasConflict(t *testing.T) {
 	// when .gitattributes doesn't exist and the PR is in conflict, then there is a conflict
 	services.f = mockFilesProvider{files: map[string]bool{".gitattributes": false}}
 	services.g = &mockGitProvider{}
-	conflict := hasConflict(pr)
-	if !conflict {
+	if checkConflict(pr).state != conflictStateConflicting {
 		t.Error("this pull request should be considered in conflict")
 	}
 
 	// when .gitattributes exists and the PR is in conflict, then there is a conflict only when merging fails
 	services.f = mockFilesProvider{files: map[string]bool{".gitattributes": true}}
-	services.g = &mockGitProvider{mergeFunc: func(ref string, a ...string) error { return errors.New("no good") }}
-	conflict = hasConflict(pr)
-	if !conflict {
+	services.g = &mockGitProvider{mergeFunc: func(ref string, a ...string) ([]ConflictFile, error) {
+		return []ConflictFile{{Path: "foo.go", Hunks: 1}}, errMergeConflict
+	}}
+	c := checkConflict(pr)
+	if c.state != conflictStateConflicting {
 		t.Error("this pull request should be considered in conflict")
 	}
 
+	if len(c.files) != 1 || c.files[0].Path != "foo.go" {
+		t.Errorf("conflicting files should have been reported, got: %v", c.files)
+	}
+
 	// when .gitattributes exists and the PR is in conflict, then there is a conflict only when merging fails
 	services.f = mockFilesProvider{files: map[string]bool{".gitattributes": true}}
-	services.g = &mockGitProvider{mergeFunc: func(ref string, a ...string) error { return nil }}
-	conflict = hasConflict(pr)
-	if conflict {
+	services.g = &mockGitProvider{mergeFunc: func(ref string, a ...string) ([]ConflictFile, error) { return nil, nil }}
+	if checkConflict(pr).state != conflictStateClean {
 		t.Error("this pull request should not be considered in conflict")
 	}
+
+	// when the PR cannot be merged locally, whether it conflicts is unknown
+	services.g = &mockGitProvider{addWorktreeFunc: func(path, ref string) error { return errors.New("no good") }}
+	c = checkConflict(pr)
+	if c.state != conflictStateUnknown || c.err == nil {
+		t.Errorf("this pull request's state should be unknown, got: %v", c.state)
+	}
+}
+
+func TestCheckConflictUnknown(t *testing.T) {
+
+	defer services.reset()
+	defer config.Reset()
+
+	pr := GithubPullRequest{
+		Mergeable: githubv4.MergeableStateUnknown,
+	}
+
+	merged := false
+	services.g = &mockGitProvider{mergeFunc: func(ref string, a ...string) ([]ConflictFile, error) {
+		merged = true
+		return nil, errMergeConflict
+	}}
+
+	// when Github has not determined the mergeable state, it is unknown
+	if checkConflict(pr).state != conflictStateUnknown || merged {
+		t.Error("this pull request's state should be unknown, without merging it locally")
+	}
+
+	// unless pull requests with unknown mergeable states are merged locally
+	config.GlobalEnable(config.GitMergeUnknown)
+	c := checkConflict(pr)
+	if c.state != conflictStateConflicting || !c.localMerge || !merged {
+		t.Errorf("this pull request should have been merged locally, and found in conflict: %+v", c)
+	}
+}
+
+func TestRepositoryFileReader(t *testing.T) {
+
+	client := &MockGithubClient{f: func(query interface{}, v map[string]interface{}) error {
+		q := query.(*repositoryFileQuery)
+
+		if v["owner"] != githubv4.String("my-org") || v["repository"] != githubv4.String(".github") {
+			t.Errorf("unexpected repository: %v", v)
+		}
+
+		if v["expression"] == githubv4.String("HEAD:prwatch.yaml") {
+			q.Repository.Object.Blob.Text = "settings: {}\n"
+		}
+
+		return nil
+	}}
+
+	read := RepositoryFileReader(client)
+
+	b, err := read("my-org/.github", "prwatch.yaml")
+	if err != nil || string(b) != "settings: {}\n" {
+		t.Errorf("file should be read from the default branch, got: %q, %v", b, err)
+	}
+
+	if b, err = read("my-org/.github", "missing.yaml"); err != nil || b != nil {
+		t.Errorf("missing files should be read as nil, got: %q, %v", b, err)
+	}
 }
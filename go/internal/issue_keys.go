@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/acaloiaro/prwatch/internal/config"
+)
+
+// IssueIDs extracts every issue key associated with a pull request, without duplicates.
+//
+// Keys are searched for in each of settings.issues.sources in order, and within each source, in the order they appear.
+// Keys are matched by settings.issues.patterns, and either Jira project keys (settings.issues.project_keys) or Github
+// issue references (#123), depending on the issue provider. When a pattern contains a capture group, its first group is
+// the issue key. Pull requests have no issues when the issue provider is 'none'.
+func IssueIDs(pr GithubPullRequest) (ids []string) {
+
+	if config.GetString(config.IssueProvider) == config.IssueProviderNone {
+		return
+	}
+
+	patterns := issuePatterns()
+	seen := map[string]bool{}
+
+	for _, source := range issueSources() {
+		for _, id := range findIssueKeys(issueSourceText(pr, source), patterns) {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	return
+}
+
+// findIssueKeys finds all issue keys in text matched by any of patterns, in the order they appear
+func findIssueKeys(text string, patterns []*regexp.Regexp) (keys []string) {
+
+	type match struct {
+		start int
+		key   string
+	}
+
+	var matches []match
+	for _, re := range patterns {
+		for _, m := range re.FindAllStringSubmatchIndex(text, -1) {
+			start, end := m[0], m[1]
+			if len(m) > 3 && m[2] >= 0 {
+				start, end = m[2], m[3]
+			}
+
+			matches = append(matches, match{start: start, key: text[start:end]})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].start < matches[j].start })
+
+	for _, m := range matches {
+		keys = append(keys, m.key)
+	}
+
+	return
+}
+
+func issueSources() []string {
+
+	sources := config.GetStringSlice(config.IssueSources)
+	if len(sources) == 0 {
+		sources = []string{config.IssueSourceBody}
+	}
+
+	return sources
+}
+
+func issueSourceText(pr GithubPullRequest, source string) string {
+
+	switch strings.ToLower(source) {
+	case config.IssueSourceBody:
+		return string(pr.BodyText)
+	case config.IssueSourceBranch:
+		return string(pr.HeadRefName)
+	case config.IssueSourceTitle:
+		return string(pr.Title)
+	}
+
+	log.Printf("unknown issue source '%s'. %s", source, config.CheckMessage(config.IssueSources, "e.g. [title, branch, body]"))
+
+	return ""
+}
+
+func issuePatterns() (patterns []*regexp.Regexp) {
+
+	for _, p := range config.GetStringSlice(config.IssuePatterns) {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			log.Printf("invalid issue pattern '%s': %v. %s", p, err, config.CheckMessage(config.IssuePatterns))
+			continue
+		}
+
+		patterns = append(patterns, re)
+	}
+
+	if config.GetString(config.IssueProvider) == config.IssueProviderGithub {
+		return append(patterns, githubIssueRef)
+	}
+
+	keys := config.GetStringSlice(config.IssueProjectKeys)
+	if len(keys) == 0 && config.GetString(config.JiraProjectName) != "" {
+		keys = []string{config.GetString(config.JiraProjectName)}
+	}
+
+	if len(keys) > 0 {
+		// keys are quoted in a copy, leaving the configured keys untouched
+		quoted := make([]string, len(keys))
+		for i, k := range keys {
+			quoted[i] = regexp.QuoteMeta(k)
+		}
+
+		// keys may be followed by anything, e.g. 'FOO-12_fix', as the greedy \d+ never ends a key part way through its number
+		patterns = append(patterns, regexp.MustCompile(fmt.Sprintf(`\b(?:%s)-\d+`, strings.Join(quoted, "|"))))
+	}
+
+	return
+}
@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/acaloiaro/prwatch/internal/config"
+)
+
+func TestIssueIDsProviders(t *testing.T) {
+
+	config.GlobalEnable(config.Jira)
+	config.GlobalSet(config.JiraProjectName, "FOO")
+
+	pr := GithubPullRequest{
+		BodyText: "Issue url is https://foobar.atlassian.net/browse/FOO-1234",
+	}
+
+	expected := []string{"FOO-1234"}
+	if ids := IssueIDs(pr); !reflect.DeepEqual(ids, expected) {
+		t.Errorf("expected issue ids: %v: got: %v", expected, ids)
+	}
+
+	config.GlobalSet(config.IssueProvider, config.IssueProviderGithub)
+	defer config.GlobalSet(config.IssueProvider, config.IssueProviderJira)
+
+	pr = GithubPullRequest{
+		BodyText: "Fixes #42",
+	}
+
+	expected = []string{"#42"}
+	if ids := IssueIDs(pr); !reflect.DeepEqual(ids, expected) {
+		t.Errorf("expected issue ids: %v: got: %v", expected, ids)
+	}
+
+	config.GlobalSet(config.IssueProvider, config.IssueProviderNone)
+	if ids := IssueIDs(pr); len(ids) > 0 {
+		t.Errorf("pull requests should have no issues when no issue tracker is used, got: %v", ids)
+	}
+}
+
+func TestIssueIDs(t *testing.T) {
+
+	defer config.GlobalSet(config.IssueSources, "")
+	defer config.GlobalSet(config.IssueProjectKeys, "")
+	defer config.GlobalSet(config.IssuePatterns, "")
+
+	config.GlobalSet(config.IssueProvider, config.IssueProviderJira)
+	config.GlobalSet(config.JiraProjectName, "FOO")
+
+	pr := GithubPullRequest{
+		Title:       "BAR-7: Fix the thinger",
+		HeadRefName: "feature/FOO-12-thinger",
+		BodyText:    "Fixes FOO-3 and BAR-7. Related to FOO-12, see ticket 991.",
+	}
+
+	// by default, only the body is searched for the jira project's keys
+	expected := []string{"FOO-3", "FOO-12"}
+	if ids := IssueIDs(pr); !reflect.DeepEqual(ids, expected) {
+		t.Errorf("expected issue ids: %v, got: %v", expected, ids)
+	}
+
+	config.GlobalSetList(config.IssueSources, []string{"title", "branch", "body"})
+	config.GlobalSetList(config.IssueProjectKeys, []string{"FOO", "BAR"})
+
+	expected = []string{"BAR-7", "FOO-12", "FOO-3"}
+	if ids := IssueIDs(pr); !reflect.DeepEqual(ids, expected) {
+		t.Errorf("expected issue ids in source priority order: %v, got: %v", expected, ids)
+	}
+
+	// custom patterns use their first capture group as the issue key
+	config.GlobalSetList(config.IssueSources, []string{"body"})
+	config.GlobalSetList(config.IssuePatterns, []string{`ticket (\d+)`})
+
+	expected = []string{"FOO-3", "BAR-7", "FOO-12", "991"}
+	if ids := IssueIDs(pr); !reflect.DeepEqual(ids, expected) {
+		t.Errorf("expected issue ids matching custom patterns: %v, got: %v", expected, ids)
+	}
+	// keys may be followed by word characters, and configured keys are not modified while matching
+	keys := []string{"FOO", "B.R"}
+	config.GlobalSetList(config.IssueProjectKeys, keys)
+	config.GlobalSetList(config.IssueSources, []string{"branch"})
+	config.GlobalSetList(config.IssuePatterns, nil)
+	pr.HeadRefName = "FOO-12_fix-B.R-3"
+
+	expected = []string{"FOO-12", "B.R-3"}
+	if ids := IssueIDs(pr); !reflect.DeepEqual(ids, expected) {
+		t.Errorf("expected issue ids followed by word characters: %v, got: %v", expected, ids)
+	}
+
+	if configured := config.GetStringSlice(config.IssueProjectKeys); !reflect.DeepEqual(configured, []string{"FOO", "B.R"}) || keys[1] != "B.R" {
+		t.Errorf("configured project keys should not be modified, got: %v", configured)
+	}
+}
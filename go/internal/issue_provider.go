@@ -7,6 +7,7 @@ package internal
 type issueProvider interface {
 	TransitionIssue(i issue) (ok bool)
 	CommentIssue(i issue) (ok bool)
+	ResolveIssue(i issue) (ok bool)
 }
 
 type issue struct {
@@ -14,6 +15,12 @@ type issue struct {
 	Key   string `json:"key,omitempty" structs:"key,omitempty"`
 	Value string `json:"value,omitempty" structs:"key,omitempty"`
 	Owner string `json:"owner,omitempty" structs:"owner,omitempty"`
+	// Files are the files that conflict in the issue's pull request, when they are known
+	Files []ConflictFile `json:"-" structs:"-"`
+	// Pull is the pull request that the issue is acted upon for
+	Pull GithubPullRequest `json:"-" structs:"-"`
+	// Reported is whether the pull request's conflict is remembered as reported by the state store
+	Reported bool `json:"-" structs:"-"`
 }
 
 type issueComment struct {
@@ -9,3 +9,26 @@ func TestJiraIssueComment(t *testing.T) {
 // TODO: finish this
 func TestJiraTransitionIssue(t *testing.T) {
 }
+
+type mockIssueProvider struct {
+	transitioned []issue
+	commented    []issue
+	resolved     []issue
+	// fail is whether transitions and comments fail
+	fail bool
+}
+
+func (m *mockIssueProvider) TransitionIssue(i issue) bool {
+	m.transitioned = append(m.transitioned, i)
+	return !m.fail
+}
+
+func (m *mockIssueProvider) CommentIssue(i issue) bool {
+	m.commented = append(m.commented, i)
+	return !m.fail
+}
+
+func (m *mockIssueProvider) ResolveIssue(i issue) bool {
+	m.resolved = append(m.resolved, i)
+	return true
+}
@@ -4,11 +4,19 @@ import (
 	"fmt"
 	"log"
 	"net/url"
+	"strings"
 
 	config "github.com/acaloiaro/prwatch/internal/config"
 	jira "github.com/andygrunwald/go-jira"
 )
 
+// Invisible anchors appended to every comment prwatch leaves on Jira, recording whether they report a conflict or its
+// resolution
+const (
+	jiraConflictMarker = "{anchor:prwatch-conflict}"
+	jiraResolvedMarker = "{anchor:prwatch-resolved}"
+)
+
 type jiraIssueProvider struct {
 	c *jira.Client
 }
@@ -37,7 +45,7 @@ func (j *jiraIssueProvider) CommentIssue(i issue) (ok bool) {
 		return
 	}
 
-	comment := j.genComment(jiraIssue)
+	comment := j.genComment(i, jiraIssue)
 	if comment == nil {
 		ok = true
 		return
@@ -61,19 +69,105 @@ func (j *jiraIssueProvider) jiraIssueFor(i issue) *jira.Issue {
 	}
 }
 
-// TransitionIssue transitions an issue's status to the one specified by settings.issues.conflict_status
+// TransitionIssue transitions an issue's status to the one its current status maps to in
+// settings.jira.transitions.status_map, or settings.issues.conflict_status. Issues are only transitioned from the
+// statuses allowed by settings.jira.transitions.allow_from and settings.jira.transitions.deny_from.
 func (j *jiraIssueProvider) TransitionIssue(i issue) (ok bool) {
 
 	if !config.UserSettingEnabled(i.Owner, config.IssueTransitions) {
 		return
 	}
 
-	transitionName := config.GetString(config.IssueConflictStatus)
+	jiraIssue, _, err := j.c.Issue.Get(i.ID, nil)
+	if err != nil {
+		log.Printf("unable to retrieve issue: '%s': %v", i.ID, err)
+		return
+	}
+
+	// issues already in a conflict status, e.g. one that status_map transitioned them to, are left there
+	currentStatus := jiraIssue.Fields.Status.Name
+	if jiraIsConflictStatus(currentStatus) {
+		log.Printf("Not transitioning issue: %s. It is already in conflict status '%s'.", i.ID, currentStatus)
+		return
+	}
+
+	if !jiraTransitionFrom(currentStatus) {
+		log.Printf("Not transitioning issue: %s. Transitions from '%s' are not allowed. %s", i.ID, currentStatus,
+			config.CheckMessage(config.JiraTransitionAllow, config.JiraTransitionDeny))
+		return
+	}
+
+	transitionName := jiraConflictStatus(currentStatus)
 	if transitionName == "" {
 		log.Println(config.CheckMessage(config.IssueConflictStatus, "e.g. 'In Progress'"))
 		return
 	}
 
+	return j.transition(i, jiraIssue, transitionName)
+}
+
+// ResolveIssue transitions issues that were previously in conflict to the status specified by
+// settings.issues.resolved_status, and comments that the conflict has been resolved.
+//
+// Issues are considered to have previously been in conflict when their status is settings.issues.conflict_status, or
+// one of the statuses in settings.jira.transitions.status_map, and prwatch reported the conflict: the state store
+// remembers it, or prwatch's last comment on the issue reported it. Issues are never resolved on their status alone,
+// since issues that are in progress may never have conflicted.
+func (j *jiraIssueProvider) ResolveIssue(i issue) (ok bool) {
+
+	resolvedStatus := config.GetString(config.IssueResolvedStatus)
+	if resolvedStatus == "" {
+		return
+	}
+
+	jiraIssue, _, err := j.c.Issue.Get(i.ID, nil)
+	if err != nil {
+		log.Printf("unable to retrieve issue: '%s': %v", i.ID, err)
+		return
+	}
+
+	if !jiraIsConflictStatus(jiraIssue.Fields.Status.Name) {
+		return
+	}
+
+	if !i.Reported && jiraLastMarker(jiraIssue) != jiraConflictMarker {
+		log.Printf("not resolving issue '%s': its conflict was not reported by prwatch", i.ID)
+		return
+	}
+
+	log.Printf("conflict resolved for issue: %s", i.ID)
+
+	ok = true
+	if config.UserSettingEnabled(i.Owner, config.IssueTransitions) {
+		ok = j.transition(i, jiraIssue, resolvedStatus)
+	}
+
+	if !config.UserSettingEnabled(i.Owner, config.IssueComments) {
+		return
+	}
+
+	var status, statusChangeMsg string
+	if config.UserSettingEnabled(i.Owner, config.IssueTransitions) {
+		status = resolvedStatus
+		statusChangeMsg = statusChangedMessage(resolvedStatus)
+	}
+
+	comment := &jira.Comment{
+		Body: fmt.Sprintf("%s\n%s", issueResolvedComment(newIssueCommentData(i, jiraMention(i, jiraIssue), status, statusChangeMsg)), jiraResolvedMarker),
+	}
+
+	_, _, err = j.c.Issue.AddComment(i.ID, comment)
+	if err != nil {
+		log.Printf("unable to leave comment on issue: '%s': %v", i.ID, err)
+		ok = false
+	}
+
+	return
+}
+
+// transition transitions an issue to the status named transitionName
+func (j *jiraIssueProvider) transition(i issue, jiraIssue *jira.Issue, transitionName string) (ok bool) {
+
 	trs, _, err := j.c.Issue.GetTransitions(i.ID)
 	if err != nil {
 		log.Printf("unable to retrieve possible transition list for issue %v: %v", i.ID, err)
@@ -93,8 +187,7 @@ func (j *jiraIssueProvider) TransitionIssue(i issue) (ok bool) {
 		return
 	}
 
-	jiraIssue, _, err := j.c.Issue.Get(i.ID, nil)
-	if err != nil || !j.shouldTransition(jiraIssue, transitionName) {
+	if !j.shouldTransition(jiraIssue, transitionName) {
 		log.Printf("Not transitioning issue: %s.", i.ID)
 		return
 	}
@@ -104,50 +197,40 @@ func (j *jiraIssueProvider) TransitionIssue(i issue) (ok bool) {
 		log.Printf("unable to transition issue: %v", err)
 	}
 
-	ok = true
+	ok = err == nil
 	return
 }
 
-func newJiraClient() *jira.Client {
-	if !config.GetBool(config.Jira) {
-		log.Fatalf("Enable jira in config.yaml: %s", config.Jira)
-	}
+// jiraTokenEnv is the environment variable of settings.jira.user's Jira API token
+const jiraTokenEnv = "JIRA_API_TOKEN"
 
-	jiraUser := config.GetString(config.JiraUser)
-	if jiraUser == "" {
-		log.Fatalf("Please set in config.yaml: %s", config.JiraUser)
-	}
+var errJiraToken = fmt.Errorf("please set %s environment variable with your Jira API token", jiraTokenEnv)
 
-	jiraHost := config.GetString(config.JiraHost)
-	if jiraHost == "" {
-		log.Fatalf("Please set in config.yaml: %s", config.JiraHost)
-	}
+// newJiraClient creates a Jira client for settings.jira.user, whose API token is JIRA_API_TOKEN. Jira settings are
+// expected to have been validated by config.Validate.
+func newJiraClient() (*jira.Client, error) {
 
-	apiToken := config.GetEnv("JIRA_API_TOKEN")
+	apiToken := config.GetEnv(jiraTokenEnv)
 	if apiToken == "" {
-		log.Fatal("Please set JIRA_API_TOKEN environment variable with your Jira API token.")
+		return nil, errJiraToken
 	}
 
+	jiraUser := config.GetString(config.JiraUser)
+	jiraHost := config.GetString(config.JiraHost)
+
 	url := fmt.Sprintf("https://%s:%s@%s", url.QueryEscape(jiraUser), apiToken, jiraHost)
 	jiraClient, err := jira.NewClient(nil, url)
-
 	if err != nil {
-		log.Fatal("Unable to connect to Jira:", err)
+		return nil, fmt.Errorf("unable to connect to Jira: %v", err)
 	}
 
-	return jiraClient
+	return jiraClient, nil
 }
 
 func (j *jiraIssueProvider) shouldTransition(issue *jira.Issue, newStatus string) bool {
 
 	currentStatus := issue.Fields.Status.Name
-
-	// TODO: Make this list less brittle
-	if currentStatus == newStatus ||
-		currentStatus == "Archived" ||
-		currentStatus == "Done" ||
-		currentStatus == "Released" ||
-		currentStatus == "Backlog" {
+	if currentStatus == newStatus {
 		return false
 	}
 
@@ -156,22 +239,112 @@ func (j *jiraIssueProvider) shouldTransition(issue *jira.Issue, newStatus string
 	return true
 }
 
-func (j *jiraIssueProvider) genComment(issue *jira.Issue) *jira.Comment {
-	conflictStatus := config.GetString(config.IssueConflictStatus)
+func (j *jiraIssueProvider) genComment(i issue, issue *jira.Issue) *jira.Comment {
+	currentStatus := issue.Fields.Status.Name
+	conflictStatus := jiraConflictStatus(currentStatus)
 
 	// only comment on issues when they are not in the correct status for in-conflict PRs
-	statusOk := issue.Fields.Status.Name == conflictStatus
+	statusOk := jiraIsConflictStatus(currentStatus)
 	if statusOk {
 		return nil
 	}
 
-	var statusChangeMsg string
-	statusChanging := issue.Fields.Status.Name != conflictStatus && config.SettingEnabled(config.IssueTransitions)
+	var status, statusChangeMsg string
+	statusChanging := conflictStatus != "" && jiraTransitionFrom(currentStatus) && config.UserSettingEnabled(i.Owner, config.IssueTransitions)
 	if statusChanging {
-		statusChangeMsg = fmt.Sprintf("This issue's status has changed to: '%s'.", conflictStatus)
+		status = conflictStatus
+		statusChangeMsg = statusChangedMessage(conflictStatus)
 	}
 
 	return &jira.Comment{
-		Body: fmt.Sprintf("[~%s]: This issue's pull request has a merge conflict. %s", issue.Fields.Assignee.Key, statusChangeMsg),
+		Body: fmt.Sprintf("%s\n%s", issueConflictComment(newIssueCommentData(i, jiraMention(i, issue), status, statusChangeMsg)), jiraConflictMarker),
+	}
+}
+
+// jiraLastMarker returns the marker of prwatch's last comment on an issue, if any
+func jiraLastMarker(issue *jira.Issue) (marker string) {
+
+	if issue.Fields == nil || issue.Fields.Comments == nil {
+		return
+	}
+
+	for _, c := range issue.Fields.Comments.Comments {
+		switch {
+		case strings.Contains(c.Body, jiraConflictMarker):
+			marker = jiraConflictMarker
+		case strings.Contains(c.Body, jiraResolvedMarker):
+			marker = jiraResolvedMarker
+		}
+	}
+
+	return
+}
+
+// jiraMention mentions an issue's assignee or, when the issue is unassigned, the pull request's author by their
+// users.<login>.handles.jira. Nobody is mentioned when the author has no Jira handle.
+func jiraMention(i issue, issue *jira.Issue) string {
+
+	if issue.Fields == nil || issue.Fields.Assignee == nil {
+		handle := config.UserHandle(i.Owner, "jira")
+		if handle == "" {
+			return ""
+		}
+
+		return fmt.Sprintf("[~%s]", handle)
 	}
+
+	return fmt.Sprintf("[~%s]", issue.Fields.Assignee.Key)
+}
+
+// jiraTransitionFrom reports whether issues may be transitioned from status when their pull request conflicts.
+// settings.jira.transitions.allow_from, when set, lists the only statuses that may be transitioned from. Otherwise,
+// any status not listed by settings.jira.transitions.deny_from may be.
+func jiraTransitionFrom(status string) bool {
+
+	if allow := config.GetStringSlice(config.JiraTransitionAllow); len(allow) > 0 {
+		return containsStatus(allow, status)
+	}
+
+	return !containsStatus(config.GetStringSlice(config.JiraTransitionDeny), status)
+}
+
+// jiraConflictStatus returns the status that issues in status are transitioned to when their pull request conflicts:
+// the status it maps to in settings.jira.transitions.status_map, or settings.issues.conflict_status
+func jiraConflictStatus(status string) string {
+
+	for from, to := range config.GetStringMapString(config.JiraTransitionMap) {
+		if strings.EqualFold(from, status) {
+			return to
+		}
+	}
+
+	return config.GetString(config.IssueConflictStatus)
+}
+
+// jiraIsConflictStatus reports whether status is one that issues are transitioned to when their pull request conflicts
+func jiraIsConflictStatus(status string) bool {
+
+	if status == config.GetString(config.IssueConflictStatus) {
+		return true
+	}
+
+	for _, to := range config.GetStringMapString(config.JiraTransitionMap) {
+		if to == status {
+			return true
+		}
+	}
+
+	return false
+}
+
+// containsStatus reports whether statuses contains status, ignoring case
+func containsStatus(statuses []string, status string) bool {
+
+	for _, s := range statuses {
+		if strings.EqualFold(s, status) {
+			return true
+		}
+	}
+
+	return false
 }
@@ -0,0 +1,221 @@
+package internal
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	jira "github.com/andygrunwald/go-jira"
+
+	"github.com/acaloiaro/prwatch/internal/config"
+)
+
+func TestJiraTransitionStatuses(t *testing.T) {
+
+	defer func() {
+		config.GlobalSet(config.IssueConflictStatus, "")
+		config.GlobalSetList(config.JiraTransitionAllow, nil)
+		config.GlobalSetList(config.JiraTransitionDeny, nil)
+		config.GlobalSetMap(config.JiraTransitionMap, nil)
+	}()
+
+	config.GlobalSet(config.IssueConflictStatus, "In Progress")
+	config.GlobalSetList(config.JiraTransitionDeny, []string{"Done", "Won't Do"})
+
+	if jiraTransitionFrom("done") || jiraTransitionFrom("Won't Do") {
+		t.Error("issues should not be transitioned from denied statuses")
+	}
+
+	if !jiraTransitionFrom("QA") {
+		t.Error("issues should be transitioned from statuses that are not denied")
+	}
+
+	config.GlobalSetList(config.JiraTransitionAllow, []string{"In Review", "Ready to Ship"})
+	if jiraTransitionFrom("QA") || !jiraTransitionFrom("Ready to Ship") {
+		t.Error("issues should only be transitioned from allowed statuses when allow_from is set")
+	}
+
+	// keys are lower case when read from the configuration file
+	config.GlobalSetMap(config.JiraTransitionMap, map[string]string{"ready to ship": "Blocked"})
+
+	if s := jiraConflictStatus("Ready to Ship"); s != "Blocked" {
+		t.Errorf("mapped statuses should be transitioned to their target, got: %s", s)
+	}
+
+	if s := jiraConflictStatus("In Review"); s != "In Progress" {
+		t.Errorf("unmapped statuses should be transitioned to the conflict status, got: %s", s)
+	}
+
+	if !jiraIsConflictStatus("Blocked") || !jiraIsConflictStatus("In Progress") || jiraIsConflictStatus("In Review") {
+		t.Error("the conflict status and mapped statuses should be considered conflict statuses")
+	}
+}
+
+// fakeJira is a Jira server with a single issue, FOO-1, which records the transitions and comments made upon it
+type fakeJira struct {
+	*httptest.Server
+	issue       string
+	transitions []string
+	comments    []string
+	// failTransitions is whether transitions are rejected
+	failTransitions bool
+}
+
+func newFakeJira(t *testing.T, issue string) *fakeJira {
+
+	f := &fakeJira{issue: issue}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/rest/api/2/issue/FOO-1":
+			w.Write([]byte(f.issue))
+		case r.Method == http.MethodGet && r.URL.Path == "/rest/api/2/issue/FOO-1/transitions":
+			w.Write([]byte(`{"transitions": [{"id": "1", "name": "In Progress"}, {"id": "2", "name": "In Review"}, {"id": "3", "name": "Blocked"}]}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/rest/api/2/issue/FOO-1/transitions":
+			var body struct {
+				Transition struct{ ID string }
+			}
+			b, _ := ioutil.ReadAll(r.Body)
+			json.Unmarshal(b, &body)
+			if f.failTransitions {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			f.transitions = append(f.transitions, body.Transition.ID)
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodPost && r.URL.Path == "/rest/api/2/issue/FOO-1/comment":
+			var c jira.Comment
+			b, _ := ioutil.ReadAll(r.Body)
+			json.Unmarshal(b, &c)
+			f.comments = append(f.comments, c.Body)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{}`))
+		default:
+			t.Errorf("unexpected Jira request: %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	return f
+}
+
+func (f *fakeJira) provider(t *testing.T) issueProvider {
+
+	c, err := jira.NewClient(nil, f.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return newJiraIssueProvider(c)
+}
+
+func TestJiraResolveIssue(t *testing.T) {
+
+	defer func() {
+		config.GlobalSet(config.IssueConflictStatus, "")
+		config.GlobalSet(config.IssueResolvedStatus, "")
+	}()
+
+	config.GlobalSet(config.IssueConflictStatus, "In Progress")
+	config.GlobalSet(config.IssueResolvedStatus, "In Review")
+	config.GlobalEnable(config.IssueTransitions)
+	config.GlobalEnable(config.IssueComments)
+
+	// unassigned issues have no assignee
+	f := newFakeJira(t, `{"key": "FOO-1", "fields": {"status": {"name": "In Progress"}}}`)
+	defer f.Close()
+
+	// issues in the conflict status are not resolved unless prwatch reported their conflict
+	if f.provider(t).ResolveIssue(issue{ID: "FOO-1", Owner: "acaloiaro"}) || len(f.transitions) > 0 || len(f.comments) > 0 {
+		t.Error("issues should not be resolved on their status alone")
+	}
+
+	// the state store remembers the conflict as reported
+	if !f.provider(t).ResolveIssue(issue{ID: "FOO-1", Owner: "acaloiaro", Reported: true}) || len(f.transitions) != 1 || f.transitions[0] != "2" {
+		t.Errorf("issues reported as conflicting should be resolved, got transitions: %v", f.transitions)
+	}
+
+	// authors without a Jira handle are not mentioned
+	if len(f.comments) != 1 || !strings.HasPrefix(f.comments[0], "This issue's") || !strings.HasSuffix(f.comments[0], jiraResolvedMarker) {
+		t.Errorf("resolved issues should be commented on without a mention, got: %v", f.comments)
+	}
+
+	// prwatch's last comment reported the conflict
+	config.GlobalSet("users.acaloiaro.handles.jira", "adrian")
+	defer config.GlobalSet("users.acaloiaro.handles.jira", "")
+
+	f.issue = `{"key": "FOO-1", "fields": {"status": {"name": "In Progress"}, "comment": {"comments": [{"body": "conflict\n` + jiraConflictMarker + `"}]}}}`
+	if !f.provider(t).ResolveIssue(issue{ID: "FOO-1", Owner: "acaloiaro"}) || len(f.transitions) != 2 {
+		t.Errorf("issues that prwatch commented were conflicting should be resolved, got transitions: %v", f.transitions)
+	}
+
+	if len(f.comments) != 2 || !strings.HasPrefix(f.comments[1], "[~adrian]: ") {
+		t.Errorf("authors should be mentioned by their Jira handle, got: %v", f.comments)
+	}
+}
+
+func TestJiraTransitionMappedStatus(t *testing.T) {
+
+	defer func() {
+		config.GlobalSet(config.IssueConflictStatus, "")
+		config.GlobalSetMap(config.JiraTransitionMap, nil)
+	}()
+
+	config.GlobalSet(config.IssueConflictStatus, "In Progress")
+	config.GlobalSetMap(config.JiraTransitionMap, map[string]string{"ready to ship": "Blocked"})
+	config.GlobalEnable(config.IssueTransitions)
+
+	f := newFakeJira(t, `{"key": "FOO-1", "fields": {"status": {"name": "Ready to Ship"}}}`)
+	defer f.Close()
+
+	if !f.provider(t).TransitionIssue(issue{ID: "FOO-1", Owner: "acaloiaro"}) || len(f.transitions) != 1 || f.transitions[0] != "3" {
+		t.Fatalf("issues in mapped statuses should be transitioned to their mapped status, got transitions: %v", f.transitions)
+	}
+
+	// the next conflict finds the issue in its mapped status, which is not transitioned to the conflict status
+	f.issue = `{"key": "FOO-1", "fields": {"status": {"name": "Blocked"}}}`
+	if f.provider(t).TransitionIssue(issue{ID: "FOO-1", Owner: "acaloiaro"}) || len(f.transitions) != 1 {
+		t.Errorf("issues already in a conflict status should not be transitioned, got transitions: %v", f.transitions)
+	}
+}
+
+func TestJiraTransitionFailure(t *testing.T) {
+
+	defer config.GlobalSet(config.IssueConflictStatus, "")
+
+	config.GlobalSet(config.IssueConflictStatus, "In Progress")
+	config.GlobalEnable(config.IssueTransitions)
+
+	f := newFakeJira(t, `{"key": "FOO-1", "fields": {"status": {"name": "In Review"}}}`)
+	defer f.Close()
+
+	f.failTransitions = true
+	if f.provider(t).TransitionIssue(issue{ID: "FOO-1", Owner: "acaloiaro"}) {
+		t.Error("transitions that Jira rejects should not be reported as taken")
+	}
+}
+
+func TestJiraCommentUserTransitions(t *testing.T) {
+
+	defer config.GlobalSet(config.IssueConflictStatus, "")
+	defer config.UserEnable("contractor", config.IssueTransitions)
+
+	config.GlobalSet(config.IssueConflictStatus, "In Progress")
+	config.GlobalEnable(config.IssueTransitions)
+	config.GlobalEnable(config.IssueComments)
+	config.UserDisableSetting("contractor", config.IssueTransitions)
+
+	f := newFakeJira(t, `{"key": "FOO-1", "fields": {"status": {"name": "In Review"}}}`)
+	defer f.Close()
+
+	if !f.provider(t).CommentIssue(issue{ID: "FOO-1", Owner: "contractor"}) || len(f.comments) != 1 {
+		t.Fatalf("issue should have been commented on, got: %v", f.comments)
+	}
+
+	if strings.Contains(f.comments[0], "status has changed") {
+		t.Errorf("comments should not report status changes when the author's transitions are disabled, got: %s", f.comments[0])
+	}
+}
@@ -0,0 +1,197 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/acaloiaro/prwatch/internal/config"
+)
+
+// notifier is an interface for notifying people of conflicts outside of issue trackers, e.g. in chat
+type notifier interface {
+	// Name identifies the notifier in logs and reports
+	Name() string
+	// Alert sends a single pull request's conflict
+	Alert(a conflictAlert) error
+	// Digest sends every conflict found in a run at once
+	Digest(alerts []conflictAlert) error
+}
+
+// conflictAlert describes a pull request that became conflicting
+type conflictAlert struct {
+	Number   int            `json:"number"`
+	Title    string         `json:"title"`
+	URL      string         `json:"url"`
+	Author   string         `json:"author"`
+	Handle   string         `json:"handle,omitempty"`
+	Base     string         `json:"base"`
+	IssueIDs []string       `json:"issue_ids"`
+	Files    []ConflictFile `json:"conflicting_files"`
+}
+
+// notifierTimeout is how long notifiers wait for webhooks to respond
+const notifierTimeout = 10 * time.Second
+
+func newConflictAlert(c *pullCheck) conflictAlert {
+
+	a := conflictAlert{
+		Number:   int(c.pull.Number),
+		Title:    string(c.pull.Title),
+		URL:      string(c.pull.URL),
+		Author:   string(c.pull.Author.Login),
+		Base:     string(c.pull.BaseRefName),
+		IssueIDs: c.issueIDs,
+		Files:    c.files,
+	}
+
+	if a.IssueIDs == nil {
+		a.IssueIDs = []string{}
+	}
+
+	if a.Files == nil {
+		a.Files = []ConflictFile{}
+	}
+
+	return a
+}
+
+// sendNotifications notifies each configured notifier of the pull requests that became conflicting, either one alert
+// per pull request, or a single digest, depending on the notifier's mode. Pull request authors may disable
+// notifications with settings.notifications.enabled.
+func sendNotifications(checks []*pullCheck) {
+
+	notifiers := services.notifiers()
+	if len(notifiers) == 0 || len(checks) == 0 {
+		return
+	}
+
+	var notified []*pullCheck
+	for _, c := range checks {
+		author := string(c.pull.Author.Login)
+		if config.UserSettingEnabled(author, config.Notifications) {
+			notified = append(notified, c)
+			continue
+		}
+
+		for _, n := range notifiers {
+			c.report.skipped(actionSendNotification, n.Name(), disabledReason(author, config.Notifications))
+		}
+	}
+
+	if len(notified) == 0 {
+		return
+	}
+
+	for _, n := range notifiers {
+		if notificationMode(n.Name()) == config.NotificationModeDigest {
+			var alerts []conflictAlert
+			for _, c := range notified {
+				alerts = append(alerts, newNotifierAlert(c, n.Name()))
+			}
+
+			err := n.Digest(alerts)
+			if err != nil {
+				log.Printf("unable to send %s digest: %v", n.Name(), err)
+			}
+
+			for _, c := range notified {
+				c.report.record(actionSendNotification, n.Name(), err == nil, fmt.Sprintf("unable to send digest: %v", err))
+			}
+
+			continue
+		}
+
+		for _, c := range notified {
+			err := n.Alert(newNotifierAlert(c, n.Name()))
+			if err != nil {
+				log.Printf("unable to send %s alert for pull request '%d': %v", n.Name(), c.pull.Number, err)
+			}
+
+			c.report.record(actionSendNotification, n.Name(), err == nil, fmt.Sprintf("unable to send alert: %v", err))
+		}
+	}
+}
+
+// newNotifierAlert creates an alert for a notifier, with the author's handle for the notifier from
+// users.<login>.handles.<notifier>
+func newNotifierAlert(c *pullCheck, name string) conflictAlert {
+
+	a := newConflictAlert(c)
+	a.Handle = config.UserHandle(a.Author, name)
+
+	return a
+}
+
+func notificationMode(name string) string {
+
+	mode := config.GetString(fmt.Sprintf(config.NotificationModeFormat, name))
+	if mode == "" {
+		return config.NotificationModeAlert
+	}
+
+	return mode
+}
+
+// alertSummary summarizes an alert in a single line of text. mention and link format the author's mention and a link
+// for the notifier.
+func alertSummary(a conflictAlert, mention string, link func(url, text string) string) string {
+
+	var b strings.Builder
+
+	if mention != "" {
+		fmt.Fprintf(&b, "%s: ", mention)
+	}
+
+	fmt.Fprintf(&b, "%s has a merge conflict with '%s'.", link(a.URL, fmt.Sprintf("#%d %s", a.Number, a.Title)), a.Base)
+
+	if len(a.IssueIDs) > 0 {
+		fmt.Fprintf(&b, " Issues: %s.", strings.Join(a.IssueIDs, ", "))
+	}
+
+	if len(a.Files) > 0 {
+		var files []string
+		for _, f := range a.Files {
+			files = append(files, f.Path+conflictHunksSummary(f.Hunks))
+		}
+
+		fmt.Fprintf(&b, " Conflicting files: %s.", strings.Join(files, ", "))
+	}
+
+	return b.String()
+}
+
+func digestTitle(alerts []conflictAlert) string {
+
+	repository := config.GetEnv("GITHUB_REPOSITORY")
+	if len(alerts) == 1 {
+		return fmt.Sprintf("1 pull request in %s has a merge conflict:", repository)
+	}
+
+	return fmt.Sprintf("%d pull requests in %s have merge conflicts:", len(alerts), repository)
+}
+
+// postJSON posts v as JSON to a webhook url
+func postJSON(client *http.Client, url string, v interface{}) (err error) {
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+
+	resp, err := client.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err = fmt.Errorf("webhook responded with status: %s", resp.Status)
+	}
+
+	return
+}
@@ -0,0 +1,198 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/acaloiaro/prwatch/internal/config"
+	"github.com/shurcooL/githubv4"
+)
+
+type mockNotifier struct {
+	name    string
+	alerts  []conflictAlert
+	digests [][]conflictAlert
+}
+
+func (m *mockNotifier) Name() string {
+	return m.name
+}
+
+func (m *mockNotifier) Alert(a conflictAlert) error {
+	m.alerts = append(m.alerts, a)
+	return nil
+}
+
+func (m *mockNotifier) Digest(alerts []conflictAlert) error {
+	m.digests = append(m.digests, alerts)
+	return nil
+}
+
+// webhookServer is a webhook that records the bodies posted to it
+func webhookServer(t *testing.T, status int) (server *httptest.Server, bodies *[]string) {
+
+	bodies = &[]string{}
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			// t.Fatal may only be called from the test's goroutine
+			t.Error(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("expected a JSON body, got: %s", r.Header.Get("Content-Type"))
+		}
+
+		*bodies = append(*bodies, string(b))
+		w.WriteHeader(status)
+	}))
+
+	return
+}
+
+var testAlert = conflictAlert{
+	Number:   1,
+	Title:    "Add <foo>",
+	URL:      "https://github.com/acaloiaro/isok/pull/1",
+	Author:   "acaloiaro",
+	Handle:   "U123",
+	Base:     "master",
+	IssueIDs: []string{"FOO-1"},
+	Files:    []ConflictFile{{Path: "foo.go", Hunks: 2}},
+}
+
+func TestSlackNotifier(t *testing.T) {
+
+	server, bodies := webhookServer(t, http.StatusOK)
+	defer server.Close()
+
+	n := newSlackNotifier(server.URL)
+	if err := n.Alert(testAlert); err != nil {
+		t.Fatal(err)
+	}
+
+	var m slackMessage
+	if err := json.Unmarshal([]byte((*bodies)[0]), &m); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "<@U123>: <https://github.com/acaloiaro/isok/pull/1|#1 Add &lt;foo&gt;> has a merge conflict with 'master'. " +
+		"Issues: FOO-1. Conflicting files: foo.go (2 conflicts)."
+	if m.Text != expected {
+		t.Errorf("expected slack message:\n%s\ngot:\n%s", expected, m.Text)
+	}
+
+	config.SetEnv("GITHUB_REPOSITORY", "acaloiaro/isok")
+	if err := n.Digest([]conflictAlert{testAlert, testAlert}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := json.Unmarshal([]byte((*bodies)[1]), &m); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(m.Text, "2 pull requests in acaloiaro/isok have merge conflicts:\n• <@U123>") || strings.Count(m.Text, "•") != 2 {
+		t.Errorf("unexpected slack digest: %s", m.Text)
+	}
+}
+
+func TestTeamsNotifier(t *testing.T) {
+
+	server, bodies := webhookServer(t, http.StatusOK)
+	defer server.Close()
+
+	alert := testAlert
+	alert.Handle = "dev@example.com"
+
+	if err := newTeamsNotifier(server.URL).Digest([]conflictAlert{alert, alert}); err != nil {
+		t.Fatal(err)
+	}
+
+	var m teamsMessage
+	if err := json.Unmarshal([]byte((*bodies)[0]), &m); err != nil {
+		t.Fatal(err)
+	}
+
+	card := m.Attachments[0].Content
+	if len(card.Body) != 3 || !strings.HasPrefix(card.Body[1].Text, "<at>dev@example.com</at>: [#1 Add <foo>](https://github.com/") {
+		t.Errorf("unexpected teams card body: %+v", card.Body)
+	}
+
+	if len(card.MSTeams.Entities) != 1 || card.MSTeams.Entities[0].Mentioned["id"] != "dev@example.com" {
+		t.Errorf("authors should be mentioned once: %+v", card.MSTeams.Entities)
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+
+	server, bodies := webhookServer(t, http.StatusOK)
+	defer server.Close()
+
+	config.SetEnv("GITHUB_REPOSITORY", "acaloiaro/isok")
+
+	if err := newWebhookNotifier(server.URL).Alert(testAlert); err != nil {
+		t.Fatal(err)
+	}
+
+	var p webhookPayload
+	if err := json.Unmarshal([]byte((*bodies)[0]), &p); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Event != webhookEventConflict || p.Repository != "acaloiaro/isok" || len(p.Pulls) != 1 || p.Pulls[0].Files[0].Hunks != 2 {
+		t.Errorf("unexpected webhook payload: %s", (*bodies)[0])
+	}
+
+	failing, _ := webhookServer(t, http.StatusInternalServerError)
+	defer failing.Close()
+
+	if err := newWebhookNotifier(failing.URL).Alert(testAlert); err == nil {
+		t.Error("an unsuccessful response should have been reported as an error")
+	}
+}
+
+func TestSendNotifications(t *testing.T) {
+
+	defer services.reset()
+	defer config.Reset()
+
+	config.GlobalSet(fmt.Sprintf(config.NotificationModeFormat, "slack"), config.NotificationModeDigest)
+	config.UserEnable("acaloiaro", config.Notifications)
+	config.UserDisableSetting("quiet", config.Notifications)
+	config.GlobalSet("users.acaloiaro.handles.slack", "U123")
+
+	slack := &mockNotifier{name: "slack"}
+	webhook := &mockNotifier{name: "webhook"}
+	services.n = []notifier{slack, webhook}
+
+	newCheck := func(number int, author string) *pullCheck {
+		pr := GithubPullRequest{Number: githubv4.Int(number), Author: actor{Login: githubv4.String(author)}}
+		return &pullCheck{pull: pr, report: newPullReport(pr)}
+	}
+
+	checks := []*pullCheck{newCheck(1, "acaloiaro"), newCheck(2, "acaloiaro"), newCheck(3, "quiet")}
+	sendNotifications(checks)
+
+	if len(slack.alerts) != 0 || len(slack.digests) != 1 || len(slack.digests[0]) != 2 {
+		t.Errorf("slack should have been sent a single digest of 2 pull requests: %+v", slack)
+	}
+
+	if slack.digests[0][0].Handle != "U123" {
+		t.Errorf("authors' slack handles should have been included: %+v", slack.digests[0][0])
+	}
+
+	if len(webhook.alerts) != 2 || len(webhook.digests) != 0 {
+		t.Errorf("webhook should have been sent an alert for each of 2 pull requests: %+v", webhook)
+	}
+
+	if a := checks[2].report.Actions; len(a) != 2 || a[0].Taken {
+		t.Errorf("notifications should have been skipped for users that disabled them: %+v", a)
+	}
+}
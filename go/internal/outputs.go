@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/acaloiaro/prwatch/internal/config"
+)
+
+// Github Actions outputs set by prwatch for downstream workflow steps
+const (
+	outputConflictCount   = "conflict_count"
+	outputConflictingPRs  = "conflicting_prs"
+	outputUnknownStatePRs = "unknown_state_prs"
+)
+
+// writeOutputs sets Github Actions outputs from a run report, when running as a Github Action. Lists of pull requests
+// are written as JSON arrays of pull request numbers, e.g. [1,2,3], for use with fromJSON() in workflows.
+func writeOutputs(r *Report) (err error) {
+
+	path := config.GetEnv("GITHUB_OUTPUT")
+	if path == "" {
+		return
+	}
+
+	conflicting := []int{}
+	unknown := []int{}
+	for _, p := range r.Pulls {
+		if p.Conflicting {
+			conflicting = append(conflicting, p.Number)
+		}
+
+		if p.State == string(conflictStateUnknown) {
+			unknown = append(unknown, p.Number)
+		}
+	}
+
+	conflictingJSON, err := json.Marshal(conflicting)
+	if err != nil {
+		return
+	}
+
+	unknownJSON, err := json.Marshal(unknown)
+	if err != nil {
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s=%s\n", outputConflictingPRs, conflictingJSON)
+	fmt.Fprintf(&b, "%s=%d\n", outputConflictCount, len(conflicting))
+	fmt.Fprintf(&b, "%s=%s\n", outputUnknownStatePRs, unknownJSON)
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(b.String())
+
+	return
+}
@@ -0,0 +1,56 @@
+package internal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/acaloiaro/prwatch/internal/config"
+	"github.com/shurcooL/githubv4"
+)
+
+func TestWriteOutputs(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "prwatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "output")
+	config.SetEnv("GITHUB_OUTPUT", path)
+	defer config.SetEnv("GITHUB_OUTPUT", "")
+
+	conflicting := newPullReport(GithubPullRequest{Number: 1, Mergeable: githubv4.MergeableStateConflicting})
+	conflicting.State = string(conflictStateConflicting)
+	conflicting.Conflicting = true
+
+	clean := newPullReport(GithubPullRequest{Number: 2, Mergeable: githubv4.MergeableStateMergeable})
+	clean.State = string(conflictStateClean)
+
+	unknown := newPullReport(GithubPullRequest{Number: 3, Mergeable: githubv4.MergeableStateUnknown})
+	unknown.State = string(conflictStateUnknown)
+
+	// pull requests that could not be merged locally are unknown, whatever Github's mergeable state
+	failed := newPullReport(GithubPullRequest{Number: 4, Mergeable: githubv4.MergeableStateConflicting})
+	failed.State = string(conflictStateUnknown)
+	failed.Error = "unable to fetch pull request"
+
+	r := newReport()
+	r.Pulls = append(r.Pulls, conflicting, clean, unknown, failed)
+
+	if err = writeOutputs(r); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "conflicting_prs=[1]\nconflict_count=1\nunknown_state_prs=[3,4]\n"
+	if string(b) != expected {
+		t.Errorf("expected outputs:\n%s\ngot:\n%s", expected, string(b))
+	}
+}
@@ -0,0 +1,107 @@
+package internal
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/acaloiaro/prwatch/internal/config"
+	"github.com/shurcooL/githubv4"
+)
+
+// botType is the __typename of pull request authors that are Github Apps, e.g. Dependabot and Renovate
+const botType = "Bot"
+
+// pullQueryFilters are the filters that can be applied by Github when listing pull requests: pull requests with any of
+// settings.pulls.include.labels, and, when settings.pulls.include.bases is a single branch rather than a pattern,
+// pull requests against that branch
+func pullQueryFilters() (labels []githubv4.String, base *githubv4.String) {
+
+	for _, label := range config.GetStringSlice(config.PullIncludeLabels) {
+		labels = append(labels, githubv4.String(label))
+	}
+
+	if bases := config.GetStringSlice(config.PullIncludeBases); len(bases) == 1 && !strings.ContainsAny(bases[0], `*?[\`) {
+		base = githubv4.NewString(githubv4.String(bases[0]))
+	}
+
+	return
+}
+
+// watched reports whether a pull request is watched, according to settings.pulls.include, settings.pulls.exclude and
+// settings.pulls.max_age, or why it is not
+func watched(pr GithubPullRequest, now time.Time) (ok bool, reason string) {
+
+	base := string(pr.BaseRefName)
+	author := string(pr.Author.Login)
+
+	var labels []string
+	for _, l := range pr.Labels.Nodes {
+		labels = append(labels, string(l.Name))
+	}
+
+	if bases := config.GetStringSlice(config.PullIncludeBases); len(bases) > 0 && !matchesAny(bases, base) {
+		return false, fmt.Sprintf("its base branch '%s' is not included by '%s'", base, config.PullIncludeBases)
+	}
+
+	if matchesAny(config.GetStringSlice(config.PullExcludeBases), base) {
+		return false, fmt.Sprintf("its base branch '%s' is excluded by '%s'", base, config.PullExcludeBases)
+	}
+
+	if include := config.GetStringSlice(config.PullIncludeLabels); len(include) > 0 && !containsAnyFold(include, labels...) {
+		return false, fmt.Sprintf("it has none of the labels included by '%s'", config.PullIncludeLabels)
+	}
+
+	if containsAnyFold(config.GetStringSlice(config.PullExcludeLabels), labels...) {
+		return false, fmt.Sprintf("it has a label excluded by '%s'", config.PullExcludeLabels)
+	}
+
+	if include := config.GetStringSlice(config.PullIncludeAuthors); len(include) > 0 && !containsAnyFold(include, author) {
+		return false, fmt.Sprintf("its author '%s' is not included by '%s'", author, config.PullIncludeAuthors)
+	}
+
+	if containsAnyFold(config.GetStringSlice(config.PullExcludeAuthors), author) {
+		return false, fmt.Sprintf("its author '%s' is excluded by '%s'", author, config.PullExcludeAuthors)
+	}
+
+	if pr.Author.Typename == botType && config.GetBool(config.PullExcludeBots) {
+		return false, fmt.Sprintf("its author '%s' is a bot, and bots are excluded by '%s'", author, config.PullExcludeBots)
+	}
+
+	if bool(pr.IsDraft) && config.GetBool(config.PullExcludeDrafts) {
+		return false, fmt.Sprintf("it is a draft, and drafts are excluded by '%s'", config.PullExcludeDrafts)
+	}
+
+	if maxAge := config.GetDuration(config.PullMaxAge); maxAge > 0 && now.Sub(pr.UpdatedAt.Time) > maxAge {
+		return false, fmt.Sprintf("it was last updated more than '%s' ago, the '%s'", maxAge, config.PullMaxAge)
+	}
+
+	return true, ""
+}
+
+// matchesAny reports whether name matches any of the glob patterns, e.g. 'release/*'
+func matchesAny(patterns []string, name string) bool {
+
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// containsAnyFold reports whether any of values is in list, ignoring case
+func containsAnyFold(list []string, values ...string) bool {
+
+	for _, l := range list {
+		for _, v := range values {
+			if strings.EqualFold(l, v) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
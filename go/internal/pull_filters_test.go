@@ -0,0 +1,163 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/acaloiaro/prwatch/internal/config"
+	"github.com/shurcooL/githubv4"
+)
+
+func TestWatched(t *testing.T) {
+
+	reset := func() {
+		config.GlobalSetList(config.PullIncludeBases, nil)
+		config.GlobalSetList(config.PullExcludeBases, nil)
+		config.GlobalSetList(config.PullIncludeLabels, nil)
+		config.GlobalSetList(config.PullExcludeLabels, nil)
+		config.GlobalSetList(config.PullIncludeAuthors, nil)
+		config.GlobalSetList(config.PullExcludeAuthors, nil)
+		config.GlobalDisable(config.PullExcludeBots)
+		config.GlobalDisable(config.PullExcludeDrafts)
+		config.GlobalSet(config.PullMaxAge, "")
+	}
+	defer reset()
+
+	now := time.Now()
+	pr := GithubPullRequest{
+		Author:      actor{Login: "renovate", Typename: botType},
+		BaseRefName: "release/1.2",
+		IsDraft:     true,
+		Number:      1,
+		UpdatedAt:   githubv4.DateTime{Time: now.Add(-48 * time.Hour)},
+	}
+	pr.Labels.Nodes = append(pr.Labels.Nodes, struct{ Name githubv4.String }{Name: "Dependencies"})
+
+	if ok, reason := watched(pr, now); !ok {
+		t.Errorf("pull requests should be watched when no filters are configured, got: %s", reason)
+	}
+
+	filters := []struct {
+		name  string
+		apply func()
+	}{
+		{"include.bases", func() { config.GlobalSetList(config.PullIncludeBases, []string{"main", "develop"}) }},
+		{"exclude.bases", func() { config.GlobalSetList(config.PullExcludeBases, []string{"release/*"}) }},
+		{"include.labels", func() { config.GlobalSetList(config.PullIncludeLabels, []string{"watch"}) }},
+		{"exclude.labels", func() { config.GlobalSetList(config.PullExcludeLabels, []string{"dependencies"}) }},
+		{"include.authors", func() { config.GlobalSetList(config.PullIncludeAuthors, []string{"someone"}) }},
+		{"exclude.authors", func() { config.GlobalSetList(config.PullExcludeAuthors, []string{"Renovate"}) }},
+		{"exclude.bots", func() { config.GlobalEnable(config.PullExcludeBots) }},
+		{"exclude.drafts", func() { config.GlobalEnable(config.PullExcludeDrafts) }},
+		{"max_age", func() { config.GlobalSet(config.PullMaxAge, "24h") }},
+	}
+
+	for _, f := range filters {
+		f.apply()
+		if ok, _ := watched(pr, now); ok {
+			t.Errorf("pull request should not be watched when filtered by '%s'", f.name)
+		}
+		reset()
+	}
+
+	config.GlobalSetList(config.PullIncludeBases, []string{"release/*"})
+	config.GlobalSetList(config.PullIncludeLabels, []string{"dependencies"})
+	config.GlobalSetList(config.PullIncludeAuthors, []string{"renovate"})
+	config.GlobalSet(config.PullMaxAge, "72h")
+
+	if ok, reason := watched(pr, now); !ok {
+		t.Errorf("pull requests matching every filter should be watched, got: %s", reason)
+	}
+}
+
+func TestPullQueryFilters(t *testing.T) {
+
+	defer func() {
+		config.GlobalSetList(config.PullIncludeBases, nil)
+		config.GlobalSetList(config.PullIncludeLabels, nil)
+	}()
+
+	if labels, base := pullQueryFilters(); labels != nil || base != nil {
+		t.Error("pull requests should not be filtered by Github when no filters are configured")
+	}
+
+	config.GlobalSetList(config.PullIncludeLabels, []string{"watch", "urgent"})
+	config.GlobalSetList(config.PullIncludeBases, []string{"main"})
+
+	labels, base := pullQueryFilters()
+	if len(labels) != 2 || labels[0] != "watch" {
+		t.Errorf("included labels should be filtered by Github, got: %v", labels)
+	}
+
+	if base == nil || *base != "main" {
+		t.Error("a single included base branch should be filtered by Github")
+	}
+
+	config.GlobalSetList(config.PullIncludeBases, []string{"release/*"})
+	if _, base := pullQueryFilters(); base != nil {
+		t.Error("base branch patterns should not be filtered by Github")
+	}
+}
+
+func TestListPullsFilters(t *testing.T) {
+
+	defer func() {
+		config.GlobalSetList(config.PullIncludeLabels, nil)
+		config.GlobalDisable(config.PullExcludeDrafts)
+	}()
+
+	config.SetEnv("GITHUB_REPOSITORY", "acaloiaro/isok")
+	client := &MockGithubClient{}
+
+	client.f = func(query interface{}, v map[string]interface{}) error {
+		if _, ok := v["labels"]; ok {
+			t.Errorf("labels should not be given when none are included, Github rejects null labels, got: %v", v["labels"])
+		}
+
+		if base, ok := v["baseRefName"].(*githubv4.String); !ok || base != nil {
+			t.Errorf("base branch should be null when no base is included, got: %v", v["baseRefName"])
+		}
+
+		q, ok := query.(*pullRequestQuery)
+		if !ok {
+			t.Fatalf("pull requests should be queried without labels, got: %T", query)
+		}
+
+		q.Repository.PullRequests = pullRequests{Nodes: []GithubPullRequest{{Number: 1}, {Number: 2, IsDraft: true}}}
+		return nil
+	}
+
+	config.GlobalEnable(config.PullExcludeDrafts)
+
+	pulls, err := ListPulls(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pulls) != 1 || pulls[0].Number != 1 {
+		t.Errorf("pull requests that are not watched should not be listed, got: %v", pulls)
+	}
+
+	config.GlobalSetList(config.PullIncludeLabels, []string{"watch"})
+
+	client.f = func(query interface{}, v map[string]interface{}) error {
+		labels, ok := v["labels"].([]githubv4.String)
+		if !ok || len(labels) != 1 || labels[0] != "watch" {
+			t.Errorf("included labels should be given to Github, got: %v", v["labels"])
+		}
+
+		q, ok := query.(*labeledPullRequestQuery)
+		if !ok {
+			t.Fatalf("pull requests should be queried by label, got: %T", query)
+		}
+
+		pr := GithubPullRequest{Number: 3}
+		pr.Labels.Nodes = append(pr.Labels.Nodes, struct{ Name githubv4.String }{Name: "watch"})
+		q.Repository.PullRequests = pullRequests{Nodes: []GithubPullRequest{pr}}
+		return nil
+	}
+
+	if pulls, err = ListPulls(client); err != nil || len(pulls) != 1 || pulls[0].Number != 3 {
+		t.Errorf("pull requests with included labels should be listed, got: %v %v", pulls, err)
+	}
+}
@@ -0,0 +1,223 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/acaloiaro/prwatch/internal/config"
+)
+
+// Actions recorded in run reports
+const (
+	actionCommentIssue     = "comment_issue"
+	actionCommentPull      = "comment_pull"
+	actionResolveIssue     = "resolve_issue"
+	actionResolvePull      = "resolve_pull"
+	actionSendNotification = "send_notification"
+	actionTransitionIssue  = "transition_issue"
+)
+
+// Report is a machine-readable report of a single run of prwatch
+type Report struct {
+	Repository string        `json:"repository"`
+	StartedAt  time.Time     `json:"started_at"`
+	FinishedAt time.Time     `json:"finished_at"`
+	DryRun     bool          `json:"dry_run"`
+	Pulls      []*PullReport `json:"pulls"`
+}
+
+// PullReport reports what was determined about a single pull request, and what was done about it
+type PullReport struct {
+	Number    int    `json:"number"`
+	URL       string `json:"url"`
+	Author    string `json:"author"`
+	Mergeable string `json:"mergeable"`
+	// State is whether the pull request has a merge conflict: conflicting, clean, or unknown
+	State       string `json:"state"`
+	Conflicting bool   `json:"conflicting"`
+	LocalMerge  bool   `json:"local_merge"`
+	// Error is why the pull request could not be merged locally, in which case whether it conflicts is unknown
+	Error string `json:"error,omitempty"`
+	// ConflictingFiles are the files that conflicted when the pull request was merged locally
+	ConflictingFiles []ConflictFile  `json:"conflicting_files"`
+	IssueIDs         []string        `json:"issue_ids"`
+	Actions          []*ActionReport `json:"actions"`
+}
+
+// ActionReport reports an action that was taken, or skipped, and why
+type ActionReport struct {
+	Action string `json:"action"`
+	Target string `json:"target"`
+	Taken  bool   `json:"taken"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func newReport() *Report {
+	return &Report{
+		Repository: config.GetEnv("GITHUB_REPOSITORY"),
+		StartedAt:  time.Now(),
+		DryRun:     config.SettingEnabled(config.DryRun),
+		Pulls:      []*PullReport{},
+	}
+}
+
+func newPullReport(pr GithubPullRequest) *PullReport {
+	return &PullReport{
+		Number:           int(pr.Number),
+		URL:              string(pr.URL),
+		Author:           string(pr.Author.Login),
+		Mergeable:        string(pr.Mergeable),
+		ConflictingFiles: []ConflictFile{},
+		IssueIDs:         []string{},
+		Actions:          []*ActionReport{},
+	}
+}
+
+// taken records an action that was taken
+func (p *PullReport) taken(action, target string) {
+	p.Actions = append(p.Actions, &ActionReport{Action: action, Target: target, Taken: true})
+}
+
+// skipped records an action that was not taken, and why
+func (p *PullReport) skipped(action, target, reason string) {
+	p.Actions = append(p.Actions, &ActionReport{Action: action, Target: target, Reason: reason})
+}
+
+// acted reports whether any action was taken upon the pull request
+func (p *PullReport) acted() bool {
+
+	for _, a := range p.Actions {
+		if a.Taken {
+			return true
+		}
+	}
+
+	return false
+}
+
+// record records the outcome of an action performed by a provider. Providers only report whether an action was taken,
+// so the reason for actions that were not taken is left to the log.
+func (p *PullReport) record(action, target string, ok bool, reason string) {
+
+	if ok {
+		p.taken(action, target)
+		return
+	}
+
+	p.skipped(action, target, reason)
+}
+
+// writeReport writes a run report as JSON to settings.report.path, and as Markdown to the Github Actions step summary,
+// when they are configured
+func writeReport(r *Report) (err error) {
+
+	if path := config.GetString(config.ReportPath); path != "" {
+		if err = writeJSONReport(r, path); err != nil {
+			return
+		}
+	}
+
+	if path := config.GetEnv("GITHUB_STEP_SUMMARY"); path != "" {
+		var f *os.File
+		f, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+
+		err = writeMarkdownReport(r, f)
+	}
+
+	return
+}
+
+func writeJSONReport(r *Report, path string) (err error) {
+
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return
+	}
+
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+func writeMarkdownReport(r *Report, w io.Writer) (err error) {
+
+	var b strings.Builder
+
+	title := "prwatch report"
+	if r.DryRun {
+		title += " (dry run)"
+	}
+
+	fmt.Fprintf(&b, "## %s: %s\n\n", title, r.Repository)
+
+	if len(r.Pulls) == 0 {
+		b.WriteString("No pull requests were checked.\n")
+		_, err = io.WriteString(w, b.String())
+		return
+	}
+
+	b.WriteString("| Pull request | Mergeable | Conflicting | Local merge | Conflicting files | Issues | Actions |\n")
+	b.WriteString("| --- | --- | --- | --- | --- | --- | --- |\n")
+
+	for _, p := range r.Pulls {
+		var actions []string
+		for _, a := range p.Actions {
+			outcome := "taken"
+			if !a.Taken {
+				outcome = fmt.Sprintf("skipped: %s", a.Reason)
+			}
+
+			actions = append(actions, fmt.Sprintf("`%s` %s (%s)", a.Action, a.Target, outcome))
+		}
+
+		var files []string
+		for _, f := range p.ConflictingFiles {
+			files = append(files, fmt.Sprintf("`%s`%s", f.Path, conflictHunksSummary(f.Hunks)))
+		}
+
+		conflicting := yesNo(p.Conflicting)
+		if p.State == string(conflictStateUnknown) {
+			conflicting = "unknown"
+		}
+
+		if p.Error != "" {
+			conflicting = fmt.Sprintf("unknown: %s", p.Error)
+		}
+
+		fmt.Fprintf(&b, "| [#%d](%s) | %s | %s | %s | %s | %s | %s |\n",
+			p.Number,
+			p.URL,
+			p.Mergeable,
+			conflicting,
+			yesNo(p.LocalMerge),
+			strings.Join(files, "<br>"),
+			strings.Join(p.IssueIDs, ", "),
+			strings.Join(actions, "<br>"),
+		)
+	}
+
+	_, err = io.WriteString(w, b.String())
+
+	return
+}
+
+func yesNo(b bool) string {
+
+	if b {
+		return "yes"
+	}
+
+	return "no"
+}
@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/acaloiaro/prwatch/internal/config"
+	"github.com/shurcooL/githubv4"
+)
+
+func TestWriteReport(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "prwatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	jsonPath := filepath.Join(dir, "report", "prwatch.json")
+	summaryPath := filepath.Join(dir, "step_summary.md")
+
+	config.GlobalSet(config.ReportPath, jsonPath)
+	config.SetEnv("GITHUB_STEP_SUMMARY", summaryPath)
+	defer config.GlobalSet(config.ReportPath, "")
+	defer config.SetEnv("GITHUB_STEP_SUMMARY", "")
+
+	p := newPullReport(GithubPullRequest{Number: 1, URL: "https://github.com/acaloiaro/isok/pull/1", Mergeable: githubv4.MergeableStateConflicting})
+	p.Conflicting = true
+	p.LocalMerge = true
+	p.ConflictingFiles = []ConflictFile{{Path: "foo.go", Hunks: 2}, {Path: "bar.go", Hunks: 1}}
+	p.IssueIDs = []string{"FOO-1"}
+	p.taken(actionCommentPull, "#1")
+	p.skipped(actionTransitionIssue, "FOO-1", "disabled")
+
+	r := newReport()
+	r.Pulls = append(r.Pulls, p)
+
+	if err = writeReport(r); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(jsonPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var written Report
+	if err = json.Unmarshal(b, &written); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(written.Pulls) != 1 || written.Pulls[0].Number != 1 || len(written.Pulls[0].Actions) != 2 ||
+		len(written.Pulls[0].ConflictingFiles) != 2 {
+		t.Errorf("unexpected JSON report: %s", string(b))
+	}
+
+	b, err = ioutil.ReadFile(summaryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summary := string(b)
+	expected := "| [#1](https://github.com/acaloiaro/isok/pull/1) | CONFLICTING | yes | yes | `foo.go` (2 conflicts)<br>`bar.go` (1 conflict) | FOO-1 | `comment_pull` #1 (taken)<br>`transition_issue` FOO-1 (skipped: disabled) |"
+	if !strings.Contains(summary, expected) {
+		t.Errorf("expected markdown summary to contain:\n%s\ngot:\n%s", expected, summary)
+	}
+}
@@ -1,5 +1,12 @@
 package internal
 
+import (
+	"log"
+	"os"
+
+	"github.com/acaloiaro/prwatch/internal/config"
+)
+
 // serviceProviders is a functional seam that enables service provider implementations to be easily swapped out
 // application-wide.
 // serviceProviders should only reference interfaces; not implementations.
@@ -7,6 +14,10 @@ type serviceProvider struct {
 	g gitProvider
 	f fileProvider
 	i issueProvider
+	c pullCommenter
+	q GithubQueryer
+	s stateStore
+	n []notifier
 }
 
 // the global services provider for all prwatch
@@ -18,11 +29,11 @@ func newProvider() serviceProvider {
 
 // TODO: reset() is useful for resetting providers during testing. However, I'm not fond of having test helper code adjacent
 // to the implementation.
-func (p serviceProvider) reset() {
+func (p *serviceProvider) reset() {
 	services = newProvider()
 }
 
-func (p serviceProvider) git() gitProvider {
+func (p *serviceProvider) git() gitProvider {
 	if p.g == nil {
 		p.g = &GitCommandLine{}
 	}
@@ -30,7 +41,7 @@ func (p serviceProvider) git() gitProvider {
 	return p.g
 }
 
-func (p serviceProvider) files() fileProvider {
+func (p *serviceProvider) files() fileProvider {
 	if p.f == nil {
 		p.f = &posixFileProvider{}
 	}
@@ -38,10 +49,118 @@ func (p serviceProvider) files() fileProvider {
 	return p.f
 }
 
-func (p serviceProvider) issues() issueProvider {
+// CheckEnvironment checks that the secrets needed by the configured services are set, so that runs fail before any pull
+// request is acted upon
+func CheckEnvironment() error {
+
+	if config.SettingEnabled(config.DryRun) || config.GetString(config.IssueProvider) != config.IssueProviderJira {
+		return nil
+	}
+
+	if config.GetEnv(jiraTokenEnv) == "" {
+		return errJiraToken
+	}
+
+	return nil
+}
+
+func (p *serviceProvider) issues() issueProvider {
 	if p.i == nil {
-		p.i = newJiraIssueProvider(newJiraClient())
+		switch {
+		case config.SettingEnabled(config.DryRun):
+			p.i = newDryRunRecorder(os.Stderr)
+		case config.GetString(config.IssueProvider) == config.IssueProviderGithub:
+			p.i = newGithubIssueProvider(p.github())
+		default:
+			c, err := newJiraClient()
+			if err != nil {
+				log.Fatalf("Unable to use Jira: %v", err)
+			}
+
+			p.i = newJiraIssueProvider(c)
+		}
 	}
 
 	return p.i
 }
+
+func (p *serviceProvider) pulls() pullCommenter {
+	if p.c == nil {
+		switch {
+		case config.SettingEnabled(config.DryRun):
+			p.c = newDryRunRecorder(os.Stderr)
+		default:
+			p.c = newGithubPullCommenter(p.github())
+		}
+	}
+
+	return p.c
+}
+
+// state returns the state recorded by the previous run. Unlike other providers, it is not reused between calls, since
+// each run must start from the state its predecessor saved.
+func (p *serviceProvider) state() stateStore {
+	if p.s != nil {
+		return p.s
+	}
+
+	path := config.GetString(config.StatePath)
+	if path == "" {
+		return noopStateStore{}
+	}
+
+	s, err := newFileStateStore(path)
+	if err != nil {
+		log.Printf("unable to load state from '%s', starting from an empty state: %v", path, err)
+	}
+
+	return s
+}
+
+// notifiers returns a notifier for each notification webhook URL set in the environment, and an email notifier when
+// settings.notifications.email.host is configured
+func (p *serviceProvider) notifiers() []notifier {
+	if p.n == nil {
+		webhooks := []struct {
+			env string
+			new func(url string) notifier
+		}{
+			{slackWebhookEnv, newSlackNotifier},
+			{teamsWebhookEnv, newTeamsNotifier},
+			{webhookURLEnv, newWebhookNotifier},
+		}
+
+		for _, w := range webhooks {
+			url := config.GetEnv(w.env)
+			if url == "" {
+				continue
+			}
+
+			n := w.new(url)
+			if config.SettingEnabled(config.DryRun) {
+				n = newDryRunNotifier(os.Stderr, n.Name())
+			}
+
+			p.n = append(p.n, n)
+		}
+
+		if config.GetString(config.EmailHost) != "" {
+			var n notifier = newEmailNotifier()
+			if config.SettingEnabled(config.DryRun) {
+				n = newDryRunNotifier(os.Stderr, n.Name())
+			}
+
+			p.n = append(p.n, n)
+		}
+	}
+
+	return p.n
+}
+
+func (p *serviceProvider) github() GithubQueryer {
+	if p.q == nil {
+		p.q = NewGithubClient()
+	}
+
+	return p.q
+}
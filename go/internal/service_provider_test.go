@@ -8,6 +8,8 @@ import (
 
 func TestServiceInitialization(t *testing.T) {
 
+	defer services.reset()
+
 	config.GlobalEnable(config.Jira)
 	config.GlobalSet(config.JiraUser, "foo")
 	config.GlobalSet(config.JiraHost, "host.dev")
@@ -22,8 +24,50 @@ func TestServiceInitialization(t *testing.T) {
 		t.Error("services provider should initialize its git provider")
 	}
 
+	if services.pulls() == nil {
+		t.Error("services provider should initialize its pull commenter")
+	}
+
 	if services.files() == nil {
 		t.Error("services provider should initialize its files provider")
 	}
 
+	if services.issues() != services.issues() || services.pulls() != services.pulls() || services.github() != services.github() {
+		t.Error("services provider should reuse the providers it initializes")
+	}
+}
+
+func TestExecuteSharesGithubClient(t *testing.T) {
+
+	defer services.reset()
+
+	client := mockPullsClient()
+	plan := &DefaultExecutionPlan{GithubClient: client}
+	plan.Execute()
+
+	if services.github() != client {
+		t.Error("providers should use the execution plan's Github client")
+	}
+}
+
+func TestCheckEnvironment(t *testing.T) {
+
+	defer config.SetEnv(jiraTokenEnv, "bar")
+	defer config.GlobalSet(config.IssueProvider, config.IssueProviderJira)
+
+	config.SetEnv(jiraTokenEnv, "")
+	if err := CheckEnvironment(); err != errJiraToken {
+		t.Errorf("a missing Jira API token should be reported up front, got: %v", err)
+	}
+
+	config.GlobalEnable(config.DryRun)
+	if err := CheckEnvironment(); err != nil {
+		t.Errorf("a Jira API token should not be needed for dry runs, got: %v", err)
+	}
+	config.GlobalDisable(config.DryRun)
+
+	config.GlobalSet(config.IssueProvider, config.IssueProviderGithub)
+	if err := CheckEnvironment(); err != nil {
+		t.Errorf("a Jira API token should not be needed without Jira, got: %v", err)
+	}
 }
@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// slackWebhookEnv is the environment variable containing the Slack incoming webhook URL
+const slackWebhookEnv = "SLACK_WEBHOOK_URL"
+
+// slackNotifier sends conflict alerts to a Slack channel through an incoming webhook. Authors are mentioned by their
+// Slack member ID, from users.<login>.handles.slack.
+type slackNotifier struct {
+	url    string
+	client *http.Client
+}
+
+type slackMessage struct {
+	Text string `json:"text"`
+}
+
+func newSlackNotifier(url string) notifier {
+	return &slackNotifier{
+		url:    url,
+		client: &http.Client{Timeout: notifierTimeout},
+	}
+}
+
+// Name identifies Slack notifications
+func (s *slackNotifier) Name() string {
+	return "slack"
+}
+
+// Alert sends a single pull request's conflict to Slack
+func (s *slackNotifier) Alert(a conflictAlert) error {
+	return postJSON(s.client, s.url, slackMessage{Text: slackSummary(a)})
+}
+
+// Digest sends a list of every conflicting pull request to Slack
+func (s *slackNotifier) Digest(alerts []conflictAlert) error {
+
+	lines := []string{digestTitle(alerts)}
+	for _, a := range alerts {
+		lines = append(lines, "• "+slackSummary(a))
+	}
+
+	return postJSON(s.client, s.url, slackMessage{Text: strings.Join(lines, "\n")})
+}
+
+func slackSummary(a conflictAlert) string {
+
+	var mention string
+	if a.Handle != "" {
+		mention = fmt.Sprintf("<@%s>", a.Handle)
+	}
+
+	return alertSummary(a, mention, func(url, text string) string {
+		return fmt.Sprintf("<%s|%s>", url, slackEscape(text))
+	})
+}
+
+// slackEscape escapes the characters that Slack treats as control characters in message text
+func slackEscape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
@@ -0,0 +1,119 @@
+package internal
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// stateStore is an interface for remembering the state of pull requests between runs, so that prwatch only acts when
+// a pull request's state changes
+type stateStore interface {
+	// Get returns the state recorded for a pull request by the previous run
+	Get(number int) (s pullState, ok bool)
+	// Put records a pull request's state for the next run
+	Put(s pullState)
+	// Save persists the state recorded by Put
+	Save() error
+}
+
+// pullState is the state of a pull request as of the last time prwatch checked it
+type pullState struct {
+	Number      int       `json:"number"`
+	Conflicting bool      `json:"conflicting"`
+	HeadSHA     string    `json:"head_sha,omitempty"`
+	NotifiedAt  time.Time `json:"notified_at,omitempty"`
+}
+
+type stateFile struct {
+	Pulls []pullState `json:"pulls"`
+}
+
+// noopStateStore is a stateStore that remembers nothing between runs
+type noopStateStore struct{}
+
+func (n noopStateStore) Get(number int) (s pullState, ok bool) {
+	return
+}
+
+func (n noopStateStore) Put(s pullState) {}
+
+func (n noopStateStore) Save() error {
+	return nil
+}
+
+// fileStateStore is a stateStore backed by a JSON file, e.g. one that is cached between workflow runs with
+// actions/cache
+type fileStateStore struct {
+	path     string
+	previous map[int]pullState
+	current  map[int]pullState
+}
+
+// newFileStateStore creates a stateStore backed by the JSON file at path. A missing file is treated as an empty store,
+// and a store is returned even when the file cannot be read.
+func newFileStateStore(path string) (store stateStore, err error) {
+
+	f := &fileStateStore{
+		path:     path,
+		previous: map[int]pullState{},
+		current:  map[int]pullState{},
+	}
+	store = f
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		err = nil
+		return
+	}
+
+	if err != nil {
+		return
+	}
+
+	var sf stateFile
+	if err = json.Unmarshal(b, &sf); err != nil {
+		return
+	}
+
+	for _, s := range sf.Pulls {
+		f.previous[s.Number] = s
+	}
+
+	return
+}
+
+func (f *fileStateStore) Get(number int) (s pullState, ok bool) {
+	s, ok = f.previous[number]
+	return
+}
+
+func (f *fileStateStore) Put(s pullState) {
+	f.current[s.Number] = s
+}
+
+// Save writes all state recorded by Put to the store's file. Pull requests that were not recorded, e.g. ones that have
+// since been closed, are dropped from the file.
+func (f *fileStateStore) Save() (err error) {
+
+	sf := stateFile{Pulls: []pullState{}}
+	for _, s := range f.current {
+		sf.Pulls = append(sf.Pulls, s)
+	}
+
+	sort.Slice(sf.Pulls, func(i, j int) bool { return sf.Pulls[i].Number < sf.Pulls[j].Number })
+
+	b, err := json.MarshalIndent(sf, "", "  ")
+	if err != nil {
+		return
+	}
+
+	if err = os.MkdirAll(filepath.Dir(f.path), os.ModePerm); err != nil {
+		return
+	}
+
+	return ioutil.WriteFile(f.path, b, 0644)
+}
@@ -0,0 +1,56 @@
+package internal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStateStore(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "prwatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state", "prwatch.json")
+
+	// a missing state file is an empty store
+	store, err := newFileStateStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := store.Get(1); ok {
+		t.Error("empty store should have no state")
+	}
+
+	notifiedAt := time.Now().UTC().Truncate(time.Second)
+	store.Put(pullState{Number: 1, Conflicting: true, HeadSHA: "abc123", NotifiedAt: notifiedAt})
+	if err = store.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = newFileStateStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, ok := store.Get(1)
+	if !ok || !s.Conflicting || s.HeadSHA != "abc123" || !s.NotifiedAt.Equal(notifiedAt) {
+		t.Errorf("state should have been restored from the previous run: %+v", s)
+	}
+
+	// state that is not recorded again is dropped
+	if err = store.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, _ = newFileStateStore(path)
+	if _, ok := store.Get(1); ok {
+		t.Error("state that was not recorded during the last run should have been dropped")
+	}
+}
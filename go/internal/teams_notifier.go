@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// teamsWebhookEnv is the environment variable containing the Microsoft Teams incoming webhook URL
+const teamsWebhookEnv = "TEAMS_WEBHOOK_URL"
+
+// teamsNotifier sends conflict alerts to a Microsoft Teams channel through an incoming webhook, as Adaptive Cards.
+// Authors are mentioned by their Teams user principal name, e.g. an email address, from users.<login>.handles.teams.
+type teamsNotifier struct {
+	url    string
+	client *http.Client
+}
+
+type teamsMessage struct {
+	Type        string            `json:"type"`
+	Attachments []teamsAttachment `json:"attachments"`
+}
+
+type teamsAttachment struct {
+	ContentType string    `json:"contentType"`
+	Content     teamsCard `json:"content"`
+}
+
+type teamsCard struct {
+	Schema  string          `json:"$schema"`
+	Type    string          `json:"type"`
+	Version string          `json:"version"`
+	Body    []teamsText     `json:"body"`
+	MSTeams teamsCardConfig `json:"msteams"`
+}
+
+type teamsText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+	Wrap bool   `json:"wrap"`
+}
+
+type teamsCardConfig struct {
+	Entities []teamsMention `json:"entities"`
+}
+
+type teamsMention struct {
+	Type      string            `json:"type"`
+	Text      string            `json:"text"`
+	Mentioned map[string]string `json:"mentioned"`
+}
+
+func newTeamsNotifier(url string) notifier {
+	return &teamsNotifier{
+		url:    url,
+		client: &http.Client{Timeout: notifierTimeout},
+	}
+}
+
+// Name identifies Microsoft Teams notifications
+func (t *teamsNotifier) Name() string {
+	return "teams"
+}
+
+// Alert sends a single pull request's conflict to Microsoft Teams
+func (t *teamsNotifier) Alert(a conflictAlert) error {
+	return postJSON(t.client, t.url, newTeamsMessage("", []conflictAlert{a}))
+}
+
+// Digest sends a list of every conflicting pull request to Microsoft Teams
+func (t *teamsNotifier) Digest(alerts []conflictAlert) error {
+	return postJSON(t.client, t.url, newTeamsMessage(digestTitle(alerts), alerts))
+}
+
+func newTeamsMessage(title string, alerts []conflictAlert) teamsMessage {
+
+	card := teamsCard{
+		Schema:  "http://adaptivecards.io/schemas/adaptive-card.json",
+		Type:    "AdaptiveCard",
+		Version: "1.2",
+		Body:    []teamsText{},
+		MSTeams: teamsCardConfig{Entities: []teamsMention{}},
+	}
+
+	if title != "" {
+		card.Body = append(card.Body, teamsText{Type: "TextBlock", Text: title, Wrap: true})
+	}
+
+	mentioned := map[string]bool{}
+	for _, a := range alerts {
+		var mention string
+		if a.Handle != "" {
+			mention = fmt.Sprintf("<at>%s</at>", a.Handle)
+
+			if !mentioned[a.Handle] {
+				mentioned[a.Handle] = true
+				card.MSTeams.Entities = append(card.MSTeams.Entities, teamsMention{
+					Type:      "mention",
+					Text:      mention,
+					Mentioned: map[string]string{"id": a.Handle, "name": a.Handle},
+				})
+			}
+		}
+
+		text := alertSummary(a, mention, func(url, text string) string {
+			return fmt.Sprintf("[%s](%s)", text, url)
+		})
+
+		card.Body = append(card.Body, teamsText{Type: "TextBlock", Text: text, Wrap: true})
+	}
+
+	return teamsMessage{
+		Type: "message",
+		Attachments: []teamsAttachment{
+			{ContentType: "application/vnd.microsoft.card.adaptive", Content: card},
+		},
+	}
+}
@@ -0,0 +1,59 @@
+package internal
+
+import (
+	"net/http"
+
+	"github.com/acaloiaro/prwatch/internal/config"
+)
+
+// webhookURLEnv is the environment variable containing the URL that generic JSON webhooks are posted to
+const webhookURLEnv = "PRWATCH_WEBHOOK_URL"
+
+// Events posted by webhookNotifier
+const (
+	webhookEventConflict = "conflict"
+	webhookEventDigest   = "digest"
+)
+
+// webhookNotifier posts conflict alerts as JSON to any URL, for integrating with services that prwatch does not
+// support. Authors' handles are taken from users.<login>.handles.webhook.
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+type webhookPayload struct {
+	Event      string          `json:"event"`
+	Repository string          `json:"repository"`
+	Pulls      []conflictAlert `json:"pulls"`
+}
+
+func newWebhookNotifier(url string) notifier {
+	return &webhookNotifier{
+		url:    url,
+		client: &http.Client{Timeout: notifierTimeout},
+	}
+}
+
+// Name identifies generic webhook notifications
+func (w *webhookNotifier) Name() string {
+	return "webhook"
+}
+
+// Alert posts a single pull request's conflict
+func (w *webhookNotifier) Alert(a conflictAlert) error {
+	return w.post(webhookEventConflict, []conflictAlert{a})
+}
+
+// Digest posts every conflicting pull request at once
+func (w *webhookNotifier) Digest(alerts []conflictAlert) error {
+	return w.post(webhookEventDigest, alerts)
+}
+
+func (w *webhookNotifier) post(event string, alerts []conflictAlert) error {
+	return postJSON(w.client, w.url, webhookPayload{
+		Event:      event,
+		Repository: config.GetEnv("GITHUB_REPOSITORY"),
+		Pulls:      alerts,
+	})
+}
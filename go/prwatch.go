@@ -1,24 +1,11 @@
 package main
 
 import (
-	"log"
+	"os"
 
-	"github.com/acaloiaro/prwatch/internal"
-	"github.com/acaloiaro/prwatch/internal/config"
+	"github.com/acaloiaro/prwatch/internal/cli"
 )
 
 func main() {
-
-	log.Println("Running...")
-
-	config.Initialize()
-	executor := internal.NewExecutor(&internal.DefaultExecutionPlan{GithubClient: internal.NewGithubClient()})
-	err := executor.Execute()
-
-	if err != nil {
-		log.Printf("Finished unsuccessfully: %s", err)
-		return
-	}
-
-	log.Println("Finished...")
+	os.Exit(cli.Run(os.Args[1:], os.Stdout, os.Stderr))
 }